@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BatchPathSeparator separates the keys of InfluxDBConf.BatchPath.
+const BatchPathSeparator = "."
+
+// Batcher splits records of batch payloads, such as
+// `{"device": "a", "readings": [{"t": 1, "temp": 1}, {"t": 2, "temp": 2}]}`,
+// into one record per element of the batch array.
+type Batcher struct {
+	path []string
+}
+
+func NewBatcher(conf *InfluxDBConf) *Batcher {
+	b := &Batcher{}
+	if conf.BatchPath != "" {
+		b.path = strings.Split(conf.BatchPath, BatchPathSeparator)
+	}
+	return b
+}
+
+// Split returns a record for each object of the batch array of r. The other
+// fields of r are shared by all elements. If r has no batch array, r is
+// returned.
+func (b *Batcher) Split(r Record) []Record {
+	if len(b.path) == 0 {
+		return []Record{r}
+	}
+	elements, ok := b.elements(r.Fields)
+	if !ok {
+		return []Record{r}
+	}
+	shared := without(r.Fields, b.path)
+	ret := make([]Record, 0, len(elements))
+	for _, e := range elements {
+		fields := make(map[string]interface{}, len(shared)+len(e))
+		for k, v := range shared {
+			fields[k] = v
+		}
+		for k, v := range e {
+			fields[k] = v
+		}
+		ret = append(ret, Record{Measurement: r.Measurement, Time: r.Time, Tags: r.Tags, Fields: fields})
+	}
+	return ret
+}
+
+// elements returns the objects of the array at the batch path.
+func (b *Batcher) elements(fields map[string]interface{}) ([]map[string]interface{}, bool) {
+	var v interface{} = fields
+	for _, key := range b.path {
+		m, ok := stringMap(v)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	array, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	ret := make([]map[string]interface{}, 0, len(array))
+	for _, e := range array {
+		if m, ok := stringMap(e); ok {
+			ret = append(ret, m)
+		}
+	}
+	return ret, true
+}
+
+// without returns a copy of the fields without the value at the path. The
+// objects on the path are copied, and dropped if nothing else is left in them.
+func without(fields map[string]interface{}, path []string) map[string]interface{} {
+	ret := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		ret[k] = v
+	}
+	if len(path) == 1 {
+		delete(ret, path[0])
+		return ret
+	}
+	if m, ok := stringMap(fields[path[0]]); ok {
+		if m = without(m, path[1:]); len(m) > 0 {
+			ret[path[0]] = m
+		} else {
+			delete(ret, path[0])
+		}
+	}
+	return ret
+}
+
+// stringMap returns v if it is an object. Maps decoded from msgpack may have
+// interface{} keys.
+func stringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(m))
+		for k, e := range m {
+			ret[fmt.Sprint(k)] = e
+		}
+		return ret, true
+	}
+	return nil, false
+}
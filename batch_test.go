@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	msgpack "github.com/vmihailenco/msgpack"
+)
+
+func Test_BatcherSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	b := NewBatcher(&InfluxDBConf{BatchPath: "data.readings"})
+	records := b.Split(Record{Fields: map[string]interface{}{
+		"device": "a",
+		"data": map[string]interface{}{
+			"readings": []interface{}{
+				map[string]interface{}{"t": float64(10), "temp": float64(1)},
+				map[string]interface{}{"t": "2021-01-02T03:04:05Z", "temp": float64(2)},
+				"skipped",
+			},
+		},
+	}})
+	assert.Equal(2, len(records))
+	assert.Equal(map[string]interface{}{
+		"device": "a", "t": float64(10), "temp": float64(1),
+	}, records[0].Fields)
+	assert.Equal(map[string]interface{}{
+		"device": "a", "t": "2021-01-02T03:04:05Z", "temp": float64(2),
+	}, records[1].Fields)
+
+	// siblings of the batch array are shared
+	records = b.Split(Record{Fields: map[string]interface{}{
+		"x": float64(3),
+		"data": map[string]interface{}{
+			"gw": float64(7),
+			"readings": []interface{}{
+				map[string]interface{}{"temp": float64(1)},
+				map[string]interface{}{"temp": float64(2)},
+			},
+		},
+	}})
+	assert.Equal(2, len(records))
+	for i, r := range records {
+		assert.Equal(map[string]interface{}{
+			"x": float64(3), "data": map[string]interface{}{"gw": float64(7)}, "temp": float64(i + 1),
+		}, r.Fields)
+	}
+
+	// not a batch payload
+	r := Record{Fields: map[string]interface{}{"temp": float64(1)}}
+	assert.Equal([]Record{r}, b.Split(r))
+}
+
+func Test_EncodeBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{BatchTime: "t"})
+	assert.Nil(err)
+
+	points := coder.Encode(Message{
+		Topic:   "a/b",
+		Payload: []byte(`[{"t": 1600000000, "temp": 1}, {"t": 1600000001.5, "temp": 2}]`),
+	})
+	assert.Equal(2, len(points))
+	assert.Equal(time.Unix(1600000000, 0), points[0].Time())
+	assert.Equal(time.Unix(1600000001, 5e8), points[1].Time())
+	assert.Equal(1, len(points[1].FieldList()))
+
+	payload, err := msgpack.Marshal(map[string]interface{}{
+		"readings": []interface{}{
+			map[string]interface{}{"t": 1600000000, "temp": 1},
+			map[string]interface{}{"t": 1600000001, "temp": 2},
+		},
+	})
+	assert.Nil(err)
+	coder, err = NewMqttSeriesEncoder(&InfluxDBConf{BatchPath: "readings", BatchTime: "t"})
+	assert.Nil(err)
+	points = coder.Encode(Message{Topic: "a/b", Payload: payload})
+	assert.Equal(2, len(points))
+	assert.Equal(time.Unix(1600000001, 0), points[1].Time())
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	gcfg "gopkg.in/gcfg.v1"
+)
+
+// BinaryLayoutConf is a layout file of binary frames:
+//
+//	[layout]
+//	order = big
+//	encoding = base64
+//
+//	[field "temp"]
+//	position = 0
+//	type = int16
+//	scale = 0.01
+type BinaryLayoutConf struct {
+	Layout struct {
+		Order    string // "big" (default) or "little" endian
+		Encoding string // "base64" or "hex" encoded frames, raw by default
+	}
+	Field map[string]*BinaryFieldConf
+}
+
+// BinaryFieldConf is a field of a binary frame.
+type BinaryFieldConf struct {
+	Position int      // byte offset in the frame
+	Type     string   // int8-64, uint8-64, float32, float64, bool or string
+	Order    string   // byte order of the field instead of the layout order
+	Size     int      // bytes of a string
+	Bits     string   // "start:length" bits of an integer, bit 0 is the least significant
+	Scale    *float64 // multiplies the value, which becomes a float
+	Offset   float64  // is added to the scaled value
+}
+
+type binaryField struct {
+	name     string
+	position int
+	typ      string
+	size     int
+	order    binary.ByteOrder
+	bitStart uint
+	bitLen   uint // 0 if the whole value is used
+	scale    float64
+	offset   float64
+}
+
+var binarySizes = map[string]int{
+	"int8": 1, "uint8": 1, "bool": 1,
+	"int16": 2, "uint16": 2,
+	"int32": 4, "uint32": 4, "float32": 4,
+	"int64": 8, "uint64": 8, "float64": 8,
+}
+
+// BinaryDecoder decodes binary frames with a layout file.
+type BinaryDecoder struct {
+	encoding string
+	fields   []binaryField
+}
+
+func NewBinaryDecoder(f *FormatConf) (*BinaryDecoder, error) {
+	if f.Layout == "" {
+		return nil, fmt.Errorf("binary: layout is empty")
+	}
+	var conf BinaryLayoutConf
+	if err := gcfg.ReadFileInto(&conf, ExpandPath(f.Layout)); err != nil {
+		return nil, fmt.Errorf("binary: %s", err)
+	}
+	return newBinaryDecoder(&conf)
+}
+
+func newBinaryDecoder(conf *BinaryLayoutConf) (*BinaryDecoder, error) {
+	d := &BinaryDecoder{encoding: conf.Layout.Encoding}
+	switch d.encoding {
+	case "", "base64", "hex":
+	default:
+		return nil, fmt.Errorf("binary: unknown encoding %q", d.encoding)
+	}
+	order, err := byteOrder(conf.Layout.Order, binary.BigEndian)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Field) == 0 {
+		return nil, fmt.Errorf("binary: layout has no field")
+	}
+
+	for name, c := range conf.Field {
+		field := binaryField{
+			name:     name,
+			position: c.Position,
+			typ:      c.Type,
+			scale:    1,
+			offset:   c.Offset,
+		}
+		if c.Scale != nil {
+			field.scale = *c.Scale
+		}
+		if field.position < 0 {
+			return nil, fmt.Errorf("binary: field %s: position is negative", name)
+		}
+		if field.order, err = byteOrder(c.Order, order); err != nil {
+			return nil, fmt.Errorf("binary: field %s: %s", name, err)
+		}
+		if field.typ == "string" {
+			if c.Size <= 0 {
+				return nil, fmt.Errorf("binary: field %s: string needs a size", name)
+			}
+			field.size = c.Size
+		} else if size, ok := binarySizes[field.typ]; ok {
+			field.size = size
+		} else {
+			return nil, fmt.Errorf("binary: field %s: unknown type %q", name, field.typ)
+		}
+		if c.Bits != "" {
+			if err := field.parseBits(c.Bits); err != nil {
+				return nil, fmt.Errorf("binary: field %s: %s", name, err)
+			}
+		}
+		d.fields = append(d.fields, field)
+	}
+	sort.Slice(d.fields, func(i, j int) bool {
+		if d.fields[i].position != d.fields[j].position {
+			return d.fields[i].position < d.fields[j].position
+		}
+		return d.fields[i].name < d.fields[j].name
+	})
+	return d, nil
+}
+
+func byteOrder(order string, def binary.ByteOrder) (binary.ByteOrder, error) {
+	switch order {
+	case "":
+		return def, nil
+	case "big":
+		return binary.BigEndian, nil
+	case "little":
+		return binary.LittleEndian, nil
+	}
+	return nil, fmt.Errorf("unknown byte order %q", order)
+}
+
+func (f *binaryField) parseBits(bits string) error {
+	if strings.HasPrefix(f.typ, "float") || f.typ == "string" {
+		return fmt.Errorf("bits of %s", f.typ)
+	}
+	v := strings.SplitN(bits, ":", 2)
+	if len(v) != 2 {
+		return fmt.Errorf("bits must be 'start:length': %s", bits)
+	}
+	start, err := strconv.ParseUint(v[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf("bits: %s", err)
+	}
+	length, err := strconv.ParseUint(v[1], 10, 8)
+	if err != nil {
+		return fmt.Errorf("bits: %s", err)
+	}
+	if length == 0 || int(start+length) > f.size*8 {
+		return fmt.Errorf("bits %s are out of %s", bits, f.typ)
+	}
+	f.bitStart = uint(start)
+	f.bitLen = uint(length)
+	return nil
+}
+
+func (d *BinaryDecoder) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (d *BinaryDecoder) Decode(msg Message) ([]Record, error) {
+	frame, err := d.unwrap(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	for _, f := range d.fields {
+		if f.position+f.size > len(frame) {
+			return nil, fmt.Errorf("frame of %d bytes is too short for %s", len(frame), f.name)
+		}
+		fields[f.name] = f.value(frame[f.position : f.position+f.size])
+	}
+	return fieldRecords(fields), nil
+}
+
+// unwrap decodes base64 or hex encoded frames.
+func (d *BinaryDecoder) unwrap(payload []byte) ([]byte, error) {
+	switch d.encoding {
+	case "base64":
+		s := strings.TrimSpace(string(payload))
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	case "hex":
+		return hex.DecodeString(string(bytes.TrimSpace(payload)))
+	}
+	return payload, nil
+}
+
+func (f *binaryField) value(b []byte) interface{} {
+	if f.typ == "string" {
+		return strings.TrimRight(string(b), "\x00")
+	}
+
+	var u uint64
+	switch f.size {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(f.order.Uint16(b))
+	case 4:
+		u = uint64(f.order.Uint32(b))
+	case 8:
+		u = f.order.Uint64(b)
+	}
+	if f.bitLen > 0 {
+		u = u >> f.bitStart & (1<<f.bitLen - 1)
+		if f.typ == "bool" {
+			return u != 0
+		}
+		if strings.HasPrefix(f.typ, "int") {
+			// the highest bit is the sign
+			shift := 64 - f.bitLen
+			v := int64(u<<shift) >> shift
+			return f.scaled(float64(v), v)
+		}
+		return f.scaled(float64(u), int64(u))
+	}
+
+	switch f.typ {
+	case "bool":
+		return u != 0
+	case "int8":
+		return f.scaled(float64(int8(u)), int64(int8(u)))
+	case "int16":
+		return f.scaled(float64(int16(u)), int64(int16(u)))
+	case "int32":
+		return f.scaled(float64(int32(u)), int64(int32(u)))
+	case "int64":
+		return f.scaled(float64(int64(u)), int64(u))
+	case "float32":
+		v := float64(math.Float32frombits(uint32(u)))
+		return f.scaled(v, v)
+	case "float64":
+		v := math.Float64frombits(u)
+		return f.scaled(v, v)
+	}
+	return f.scaled(float64(u), u)
+}
+
+// scaled returns the value scaled and offset as a float, or the raw value if
+// the field is not scaled.
+func (f *binaryField) scaled(v float64, raw interface{}) interface{} {
+	if f.scale != 1 || f.offset != 0 {
+		return v*f.scale + f.offset
+	}
+	return raw
+}
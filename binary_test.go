@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testLayout = `
+[layout]
+encoding = base64
+
+[field "temp"]
+position = 0
+type = int16
+scale = 0.01
+
+[field "battery"]
+position = 2
+type = uint8
+
+[field "charging"]
+position = 3
+type = bool
+bits = 7:1
+
+[field "mode"]
+position = 3
+type = uint8
+bits = 0:3
+
+[field "counter"]
+position = 4
+type = uint32
+order = little
+
+[field "pressure"]
+position = 8
+type = float32
+offset = -1000
+
+[field "name"]
+position = 12
+type = string
+size = 4
+`
+
+func Test_BinaryDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "frame.ini")
+	assert.Nil(ioutil.WriteFile(path, []byte(testLayout), 0600))
+	d, err := NewBinaryDecoder(&FormatConf{Layout: path})
+	assert.Nil(err)
+
+	frame := []byte{
+		0xf8, 0x30, // -2000
+		0x64,                   // 100
+		0x85,                   // charging, mode 5
+		0x01, 0x02, 0x00, 0x00, // 513
+		0x44, 0x7d, 0x00, 0x00, // 1012.0
+		'a', 'b', 0, 0,
+	}
+	records, err := d.Decode(Message{Payload: []byte(base64.StdEncoding.EncodeToString(frame))})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"temp":     -20.0,
+		"battery":  uint64(100),
+		"charging": true,
+		"mode":     int64(5),
+		"counter":  uint64(513),
+		"pressure": 12.0,
+		"name":     "ab",
+	}, records[0].Fields)
+
+	_, err = d.Decode(Message{Payload: []byte(base64.StdEncoding.EncodeToString(frame[:10]))})
+	assert.NotNil(err)
+	_, err = d.Decode(Message{Payload: []byte("!!")})
+	assert.NotNil(err)
+}
+
+func Test_BinaryLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := newBinaryDecoder(&BinaryLayoutConf{
+		Layout: struct {
+			Order    string
+			Encoding string
+		}{Order: "little", Encoding: "hex"},
+		Field: map[string]*BinaryFieldConf{
+			"a": {Position: 0, Type: "uint16"},
+			"b": {Position: 2, Type: "int8", Order: "big"},
+		},
+	})
+	assert.Nil(err)
+	records, err := d.Decode(Message{Payload: []byte("0102ff\n")})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"a": uint64(0x0201), "b": int64(-1)}, records[0].Fields)
+
+	// bits of signed integers are sign-extended, and a scale of 0 is kept
+	zero := 0.0
+	d, err = newBinaryDecoder(&BinaryLayoutConf{
+		Field: map[string]*BinaryFieldConf{
+			"low":   {Position: 0, Type: "int8", Bits: "0:4"},
+			"high":  {Position: 0, Type: "int8", Bits: "4:4"},
+			"flags": {Position: 0, Type: "uint8", Bits: "4:4"},
+			"zero":  {Position: 0, Type: "uint8", Scale: &zero, Offset: 3},
+		},
+	})
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: []byte{0x9e}})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"low":   int64(-2),
+		"high":  int64(-7),
+		"flags": int64(9),
+		"zero":  3.0,
+	}, records[0].Fields)
+
+	invalid := []*BinaryFieldConf{
+		{Type: "int24"},
+		{Type: "string"},
+		{Type: "float32", Bits: "0:1"},
+		{Type: "uint8", Bits: "4:5"},
+		{Type: "uint8", Order: "middle"},
+		{Type: "uint8", Position: -1},
+	}
+	for i, f := range invalid {
+		_, err := newBinaryDecoder(&BinaryLayoutConf{Field: map[string]*BinaryFieldConf{"a": f}})
+		assert.NotNil(err, "case %d", i)
+	}
+	_, err = NewBinaryDecoder(&FormatConf{})
+	assert.NotNil(err)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	BytesHex    = "hex"    // byte strings are stored as hex strings
+	BytesBase64 = "base64" // byte strings are stored as base64 strings
+	BytesString = "string" // byte strings are stored as they are
+)
+
+var cborDecMode, _ = cbor.DecOptions{
+	IntDec: cbor.IntDecConvertNone,
+}.DecMode()
+
+// CBORDecoder decodes a CBOR map or an array of maps. The first epoch or
+// date/time value (tag 1 or 0) of a map is the time of the record, and byte
+// strings are stored as hex strings by default.
+type CBORDecoder struct {
+	bytes string
+}
+
+func NewCBORDecoder(bytes string) (*CBORDecoder, error) {
+	switch bytes {
+	case "":
+		bytes = BytesHex
+	case BytesHex, BytesBase64, BytesString:
+	default:
+		return nil, fmt.Errorf("cbor: unknown bytes %q", bytes)
+	}
+	return &CBORDecoder{bytes: bytes}, nil
+}
+
+func (d *CBORDecoder) ContentType() string {
+	return "application/cbor"
+}
+
+// Detect reports whether the payload starts with a CBOR map or the
+// self-described CBOR tag. Arrays are not detected, their first byte is also
+// the first byte of a msgpack map; a batch of CBOR maps needs the tag or a
+// mqforward-format section.
+func (d *CBORDecoder) Detect(msg Message) bool {
+	if len(msg.Payload) == 0 {
+		return false
+	}
+	b := msg.Payload[0]
+	if b >= 0xa0 && b <= 0xbb || b == 0xbf { // map
+		return true
+	}
+	return len(msg.Payload) >= 3 && b == 0xd9 && msg.Payload[1] == 0xd9 && msg.Payload[2] == 0xf7
+}
+
+func (d *CBORDecoder) Decode(msg Message) ([]Record, error) {
+	v, err := decodeCBOR(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	records, err := objectRecords(v)
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		records[i] = d.record(records[i].Fields)
+	}
+	return records, nil
+}
+
+// decodeCBOR decodes a payload into maps with string keys.
+func decodeCBOR(payload []byte) (interface{}, error) {
+	var v interface{}
+	if err := cborDecMode.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	if tag, ok := v.(cbor.Tag); ok && tag.Number == 55799 {
+		// self-described CBOR
+		v = tag.Content
+	}
+	return cborMaps(v), nil
+}
+
+// cborMaps converts the maps of v to maps with string keys.
+func cborMaps(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			if b, ok := k.(cbor.ByteString); ok {
+				k = string(b)
+			}
+			ret[fmt.Sprint(k)] = cborMaps(e)
+		}
+		return ret
+	case []interface{}:
+		for i, e := range t {
+			t[i] = cborMaps(e)
+		}
+		return t
+	}
+	return v
+}
+
+// record sets the time of the record and converts the values of the fields.
+func (d *CBORDecoder) record(fields map[string]interface{}) Record {
+	r := Record{Fields: fields}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if t, ok := fields[k].(time.Time); ok {
+			r.Time = t
+			delete(fields, k)
+			break
+		}
+	}
+
+	for k, v := range fields {
+		fields[k] = d.value(v)
+	}
+	return r
+}
+
+// value converts CBOR values to field values.
+func (d *CBORDecoder) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case uint64:
+		if t <= math.MaxInt64 {
+			return int64(t)
+		}
+	case []byte:
+		switch d.bytes {
+		case BytesBase64:
+			return base64.StdEncoding.EncodeToString(t)
+		case BytesString:
+			return string(t)
+		}
+		return hex.EncodeToString(t)
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	case cbor.Tag:
+		// unknown tags are stored as their content
+		return d.value(t.Content)
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = d.value(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = d.value(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	msgpack "github.com/vmihailenco/msgpack"
+)
+
+func Test_CBORDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	payload, err := cbor.Marshal(map[string]interface{}{
+		"time": cbor.Tag{Number: 1, Content: 1600000000},
+		"temp": 21.5,
+		"n":    -3,
+		"id":   []byte{0xca, 0xfe},
+		"env":  map[int]interface{}{1: uint64(7)},
+	})
+	assert.Nil(err)
+
+	d, err := NewCBORDecoder("")
+	assert.Nil(err)
+	assert.True(d.Detect(Message{Payload: payload}))
+
+	records, err := d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal(1, len(records))
+	assert.Equal(time.Unix(1600000000, 0), records[0].Time)
+	assert.Equal(map[string]interface{}{
+		"temp": 21.5,
+		"n":    int64(-3),
+		"id":   "cafe",
+		"env":  map[string]interface{}{"1": int64(7)},
+	}, records[0].Fields)
+
+	d, err = NewCBORDecoder("base64")
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal("yv4=", records[0].Fields["id"])
+
+	_, err = NewCBORDecoder("utf8")
+	assert.NotNil(err)
+}
+
+func Test_DecoderRegistryCBOR(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{})
+	assert.Nil(err)
+
+	// arrays are detected only with the self-described CBOR tag
+	payload, err := cbor.Marshal(cbor.Tag{Number: 55799, Content: []interface{}{
+		map[string]interface{}{"x": 1},
+		map[string]interface{}{"x": 2},
+	}})
+	assert.Nil(err)
+	records, d, err := r.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/cbor", d.ContentType())
+	assert.Equal(2, len(records))
+
+	payload, err = cbor.Marshal(map[string]interface{}{"x": 1})
+	assert.Nil(err)
+	_, d, err = r.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/cbor", d.ContentType())
+
+	_, d, err = r.Decode(Message{ContentType: "application/vnd.foo+cbor", Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/cbor", d.ContentType())
+
+	// the msgpack map {"a": "ab"} is also a well-formed CBOR array
+	payload, err = msgpack.Marshal(map[string]interface{}{"a": "ab"})
+	assert.Nil(err)
+	assert.Nil(cbor.Wellformed(payload))
+	records, d, err = r.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/msgpack", d.ContentType())
+	assert.Equal("ab", records[0].Fields["a"])
+
+	_, err = objectRecords([]interface{}{1, 2})
+	assert.NotNil(err)
+}
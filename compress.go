@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionAuto   = "auto"   // detected by the magic bytes (default)
+	CompressionNone   = "none"   // payloads are not decompressed
+	CompressionGzip   = "gzip"   // RFC 1952
+	CompressionZlib   = "zlib"   // RFC 1950
+	CompressionZstd   = "zstd"   // RFC 8878 frames
+	CompressionSnappy = "snappy" // framed stream or a block
+)
+
+// DefaultMaxDecompressedSize is the maximum bytes of a decompressed payload.
+const DefaultMaxDecompressedSize = 16 << 20
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// Decompressor decompresses payloads before they are decoded.
+type Decompressor struct {
+	compression string
+	maxSize     int64
+}
+
+func NewDecompressor(compression string, maxSize int) (*Decompressor, error) {
+	switch compression {
+	case "":
+		compression = CompressionAuto
+	case CompressionAuto, CompressionNone, CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy:
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	if maxSize < 0 {
+		return nil, fmt.Errorf("max decompressed size is negative")
+	}
+	if maxSize == 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+	return &Decompressor{compression: compression, maxSize: int64(maxSize)}, nil
+}
+
+// Decompress returns the decompressed payload. Detected payloads which fail
+// to decompress are returned as they are, as the magic bytes may be data.
+func (d *Decompressor) Decompress(payload []byte) ([]byte, error) {
+	switch d.compression {
+	case CompressionNone:
+		return payload, nil
+	case CompressionAuto:
+		compression := detectCompression(payload)
+		if compression == "" {
+			return payload, nil
+		}
+		b, err := d.decompress(compression, payload)
+		if err == errDecompressedSize {
+			return nil, fmt.Errorf("%s: %s", compression, err)
+		}
+		if err != nil {
+			return payload, nil
+		}
+		return b, nil
+	}
+	b, err := d.decompress(d.compression, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", d.compression, err)
+	}
+	return b, nil
+}
+
+// detectCompression returns the compression of the payload by its magic
+// bytes, or "" if it is not compressed. Snappy blocks have no magic bytes.
+func detectCompression(payload []byte) string {
+	switch {
+	case bytes.HasPrefix(payload, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(payload, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(payload, snappyMagic):
+		return CompressionSnappy
+	case len(payload) >= 2 && payload[0]&0x0f == 8 && payload[0]>>4 <= 7 &&
+		(uint(payload[0])<<8|uint(payload[1]))%31 == 0:
+		// deflate with a window of up to 32K and a header checksum
+		return CompressionZlib
+	}
+	return ""
+}
+
+var errDecompressedSize = fmt.Errorf("decompressed payload is too large")
+
+func (d *Decompressor) decompress(compression string, payload []byte) ([]byte, error) {
+	var r io.Reader
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case CompressionZlib:
+		z, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		r = z
+	case CompressionZstd:
+		// RFC 8878 recommends windows of up to 8 MiB
+		window := max(d.maxSize, 8<<20)
+		z, err := zstd.NewReader(bytes.NewReader(payload),
+			zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(uint64(window)))
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		r = z
+	case CompressionSnappy:
+		if !bytes.HasPrefix(payload, snappyMagic) {
+			n, err := snappy.DecodedLen(payload)
+			if err != nil {
+				return nil, err
+			}
+			if int64(n) > d.maxSize {
+				return nil, errDecompressedSize
+			}
+			return snappy.Decode(nil, payload)
+		}
+		r = snappy.NewReader(bytes.NewReader(payload))
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, d.maxSize+1))
+	if err == zstd.ErrWindowSizeExceeded {
+		return nil, errDecompressedSize
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > d.maxSize {
+		return nil, errDecompressedSize
+	}
+	return b, nil
+}
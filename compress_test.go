@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func compressed(t *testing.T, compression string, b []byte) []byte {
+	var buf bytes.Buffer
+	switch compression {
+	case CompressionGzip:
+		w := gzip.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+	case CompressionZlib:
+		w := zlib.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(b)
+		w.Close()
+	case CompressionSnappy:
+		w := snappy.NewBufferedWriter(&buf)
+		w.Write(b)
+		w.Close()
+	}
+	return buf.Bytes()
+}
+
+func Test_Decompress(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDecompressor("", 0)
+	assert.Nil(err)
+	payload := []byte(`{"temp": 21.5}`)
+	for _, c := range []string{CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy} {
+		b, err := d.Decompress(compressed(t, c, payload))
+		assert.Nil(err, c)
+		assert.Equal(payload, b, c)
+	}
+
+	// uncompressed payloads and false detections are kept
+	b, err := d.Decompress(payload)
+	assert.Nil(err)
+	assert.Equal(payload, b)
+	b, err = d.Decompress([]byte("x^2"))
+	assert.Nil(err)
+	assert.Equal([]byte("x^2"), b)
+
+	// snappy blocks have no magic bytes
+	d, err = NewDecompressor(CompressionSnappy, 0)
+	assert.Nil(err)
+	b, err = d.Decompress(snappy.Encode(nil, payload))
+	assert.Nil(err)
+	assert.Equal(payload, b)
+
+	d, err = NewDecompressor(CompressionGzip, 0)
+	assert.Nil(err)
+	_, err = d.Decompress(payload)
+	assert.NotNil(err)
+
+	d, err = NewDecompressor(CompressionNone, 0)
+	assert.Nil(err)
+	b, err = d.Decompress(compressed(t, CompressionGzip, payload))
+	assert.Nil(err)
+	assert.NotEqual(payload, b)
+
+	_, err = NewDecompressor("lz4", 0)
+	assert.NotNil(err)
+}
+
+func Test_DecompressLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewDecompressor("", 1024)
+	assert.Nil(err)
+	large := bytes.Repeat([]byte("0"), 1025)
+	for _, c := range []string{CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy} {
+		_, err := d.Decompress(compressed(t, c, large))
+		assert.NotNil(err, c)
+		b, err := d.Decompress(compressed(t, c, large[:1024]))
+		assert.Nil(err, c)
+		assert.Len(b, 1024)
+	}
+
+	d, err = NewDecompressor(CompressionSnappy, 1024)
+	assert.Nil(err)
+	_, err = d.Decompress(snappy.Encode(nil, large))
+	assert.NotNil(err)
+}
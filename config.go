@@ -17,6 +17,11 @@ type Config struct {
 	General  GeneralConf
 	Mqtt     MqttConf     `gcfg:"mqforward-mqtt"`
 	InfluxDB InfluxDBConf `gcfg:"mqforward-influxdb"`
+
+	Subscription map[string]*SubscriptionConf `gcfg:"mqforward-subscription"`
+	Format       map[string]*FormatConf       `gcfg:"mqforward-format"`
+	Schema       map[string]*SchemaConf       `gcfg:"mqforward-schema"`
+	Verify       map[string]*VerifyConf       `gcfg:"mqforward-verify"`
 }
 
 func UserHomeDir() string {
@@ -47,5 +52,10 @@ func LoadConf(path string) (MqttConf, InfluxDBConf, error) {
 		log.SetLevel(log.DebugLevel)
 	}
 
+	cfg.Mqtt.Subscriptions = cfg.Subscription
+	cfg.InfluxDB.Formats = cfg.Format
+	cfg.InfluxDB.Schemas = cfg.Schema
+	cfg.InfluxDB.Verify = cfg.Verify
+
 	return cfg.Mqtt, cfg.InfluxDB, nil
 }
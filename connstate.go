@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultConnectRetryInterval = time.Second
+	DefaultMaxReconnectInterval = time.Minute
+)
+
+// ConnState is the state of the connection to the MQTT broker.
+type ConnState string
+
+const (
+	StateDown         ConnState = "down"
+	StateConnected    ConnState = "connected"
+	StateReconnecting ConnState = "reconnecting"
+)
+
+// ConnectionStatus is a snapshot of the connection to the MQTT broker.
+type ConnectionStatus struct {
+	State            ConnState
+	Since            time.Time // when State was entered
+	Broker           string    // broker of the last connection attempt
+	LastConnected    time.Time
+	LastDisconnected time.Time
+	LastError        error
+}
+
+// connTracker tracks the connection to the MQTT broker. It is safe for
+// concurrent use.
+type connTracker struct {
+	lock   sync.Mutex
+	status ConnectionStatus
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		status: ConnectionStatus{
+			State: StateDown,
+			Since: time.Now(),
+		},
+	}
+}
+
+// Status returns the current connection status.
+func (t *connTracker) Status() ConnectionStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status
+}
+
+func (t *connTracker) setState(state ConnState, now time.Time) {
+	if t.status.State != state {
+		t.status.State = state
+		t.status.Since = now
+	}
+}
+
+// attempt records the broker of a connection attempt.
+func (t *connTracker) attempt(broker string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.Broker = broker
+}
+
+func (t *connTracker) connected() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.setState(StateConnected, now)
+	t.status.LastConnected = now
+}
+
+// lost records a lost connection which is being reconnected.
+func (t *connTracker) lost(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.setState(StateReconnecting, now)
+	t.status.LastDisconnected = now
+	if err != nil {
+		t.status.LastError = err
+	}
+}
+
+// failed records a failed connection attempt.
+func (t *connTracker) failed(err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status.LastError = err
+}
+
+// down records that the client is not connected and does not reconnect.
+func (t *connTracker) down() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	if t.status.State == StateConnected {
+		t.status.LastDisconnected = now
+	}
+	t.setState(StateDown, now)
+}
+
+// backoff returns the wait before the retry attempt n, counted from 0. It
+// doubles from initial up to max.
+func backoff(n int, initial, max time.Duration) time.Duration {
+	wait := initial
+	for i := 0; i < n && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// retryIntervals returns the initial connect retry interval and the maximum
+// reconnect interval from conf.
+func retryIntervals(conf MqttConf) (time.Duration, time.Duration) {
+	initial := DefaultConnectRetryInterval
+	if conf.ConnectRetryInterval > 0 {
+		initial = time.Duration(conf.ConnectRetryInterval) * time.Second
+	}
+	max := DefaultMaxReconnectInterval
+	if conf.MaxReconnectInterval > 0 {
+		max = time.Duration(conf.MaxReconnectInterval) * time.Second
+	}
+	if max < initial {
+		max = initial
+	}
+	return initial, max
+}
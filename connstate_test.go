@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Backoff(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(time.Second, backoff(0, time.Second, time.Minute))
+	assert.Equal(4*time.Second, backoff(2, time.Second, time.Minute))
+	assert.Equal(time.Minute, backoff(10, time.Second, time.Minute))
+}
+
+func Test_ConnTracker(t *testing.T) {
+	assert := assert.New(t)
+
+	tr := newConnTracker()
+	assert.Equal(StateDown, tr.Status().State)
+
+	tr.attempt("tcp://localhost:1883")
+	tr.connected()
+	s := tr.Status()
+	assert.Equal(StateConnected, s.State)
+	assert.Equal("tcp://localhost:1883", s.Broker)
+	assert.False(s.LastConnected.IsZero())
+
+	tr.lost(errors.New("EOF"))
+	s = tr.Status()
+	assert.Equal(StateReconnecting, s.State)
+	assert.EqualError(s.LastError, "EOF")
+	assert.False(s.LastDisconnected.IsZero())
+
+	tr.down()
+	assert.Equal(StateDown, tr.Status().State)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+type csvColumn struct {
+	name string // skipped if "-"
+	typ  string // empty if the value type is guessed
+}
+
+// CSVDecoder decodes CSV lines with configured columns. Every line is a
+// record, and empty values are omitted.
+type CSVDecoder struct {
+	columns []csvColumn
+	comma   rune
+}
+
+func NewCSVDecoder(f *FormatConf) (*CSVDecoder, error) {
+	if len(f.Column) == 0 {
+		return nil, fmt.Errorf("csv: column is empty")
+	}
+	d := &CSVDecoder{comma: ','}
+	if f.Separator != "" {
+		r, n := utf8.DecodeRuneInString(f.Separator)
+		if n != len(f.Separator) {
+			return nil, fmt.Errorf("csv: separator must be one character: %q", f.Separator)
+		}
+		d.comma = r
+	}
+	for _, c := range f.Column {
+		v := strings.Fields(c)
+		if len(v) < 1 || len(v) > 2 {
+			return nil, fmt.Errorf("csv: column must be 'name [type]': %s", c)
+		}
+		col := csvColumn{name: v[0]}
+		if len(v) == 2 {
+			if !validFieldType(v[1]) {
+				return nil, fmt.Errorf("csv: column %s: unknown type %q", v[0], v[1])
+			}
+			col.typ = v[1]
+		}
+		d.columns = append(d.columns, col)
+	}
+	return d, nil
+}
+
+func (d *CSVDecoder) ContentType() string {
+	return "text/csv"
+}
+
+func (d *CSVDecoder) Decode(msg Message) ([]Record, error) {
+	reader := csv.NewReader(bytes.NewReader(msg.Payload))
+	reader.Comma = d.comma
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	ret := []Record{}
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields := map[string]interface{}{}
+		for i, col := range d.columns {
+			if i >= len(line) {
+				break
+			}
+			text := strings.TrimSpace(line[i])
+			if col.name == "-" || text == "" {
+				continue
+			}
+			if col.typ == "" {
+				fields[col.name] = textValue(text)
+				continue
+			}
+			v, err := convertField(text, col.typ)
+			if err != nil {
+				n, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("csv: line %d: %s: %s", n, col.name, err)
+			}
+			fields[col.name] = v
+		}
+		ret = append(ret, Record{Fields: fields})
+	}
+	return ret, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CSVDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewCSVDecoder(&FormatConf{
+		Column: []string{"time", "-", "temp float", "hum", "state"},
+	})
+	assert.Nil(err)
+	records, err := d.Decode(Message{Payload: []byte(
+		"1600000000,x,21,40,ok\n1600000010,y,21.5,,\"running, fine\"\n")})
+	assert.Nil(err)
+	assert.Equal([]Record{
+		{Fields: map[string]interface{}{
+			"time": int64(1600000000), "temp": float64(21), "hum": int64(40), "state": "ok",
+		}},
+		{Fields: map[string]interface{}{
+			"time": int64(1600000010), "temp": 21.5, "state": "running, fine",
+		}},
+	}, records)
+
+	_, err = d.Decode(Message{Payload: []byte("1,x,20\n\n2,y,21,40,\"a\nb\"\n3,z,warm")})
+	assert.EqualError(err, `csv: line 5: temp: strconv.ParseFloat: parsing "warm": invalid syntax`)
+
+	d, err = NewCSVDecoder(&FormatConf{Column: []string{"a", "b"}, Separator: ";"})
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: []byte("1;2;3")})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"a": int64(1), "b": int64(2)}, records[0].Fields)
+
+	_, err = NewCSVDecoder(&FormatConf{})
+	assert.NotNil(err)
+	_, err = NewCSVDecoder(&FormatConf{Column: []string{"a"}, Separator: ";;"})
+	assert.NotNil(err)
+	_, err = NewCSVDecoder(&FormatConf{Column: []string{"a decimal"}})
+	assert.NotNil(err)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	msgpack "github.com/vmihailenco/msgpack"
+)
+
+// Decoder decodes a payload into records.
+type Decoder interface {
+	// ContentType returns the MIME type of the payloads the decoder reads.
+	ContentType() string
+	Decode(msg Message) ([]Record, error)
+}
+
+// Detector is implemented by decoders which are tried by auto-detection. Detect
+// is a cheap check whether the message may be for the decoder.
+type Detector interface {
+	Detect(msg Message) bool
+}
+
+// FormatConf binds a decoder to topics, from a [mqforward-format "name"]
+// section.
+type FormatConf struct {
+	Topic       []string // topic filters matched against the topic as published
+	Decoder     string   // decoder name or content type, auto-detected if empty
+	Compression string   // of the payloads, InfluxDBConf.Compression by default
+
+	// protobuf
+	Descriptor []string // descriptor set files
+	Message    string   // full name of the message type
+	Enums      string   // "string" (default) or "number"
+
+	// cbor
+	Bytes string // byte strings as "hex" (default), "base64" or "string"
+
+	// line protocol
+	Precision string // of timestamps: "ns" (default), "us", "ms" or "s"
+
+	// senml
+	SenmlPoints bool   // one point per record instead of one field per record name
+	UnitTag     string // tag of the record unit
+
+	// plain
+	Field   string   // field name, InfluxDBConf.PlainField by default
+	True    []string // literals of true, "true" by default
+	False   []string // literals of false, "false" by default
+	Enum    []string // "literal=number" maps a literal to an integer
+	Strings bool     // stores other text as a string field
+
+	// xml, csv and kv
+	Select    []string // "name path [type]" selects an XML element text or attribute
+	Column    []string // "name [type]" of the CSV columns in order, "-" skips a column
+	Separator string   // of CSV columns, "," by default, or of key=value pairs
+
+	// binary
+	Layout string // layout file of the frames
+}
+
+type decoderBinding struct {
+	filters    []string
+	decoder    Decoder // nil if the decoder is selected by the message
+	decompress *Decompressor
+}
+
+// DecoderRegistry selects the decoder of a message. A decoder bound to the
+// topic is used first, then the decoder of the MQTT v5 content type, and
+// then the first auto-detected decoder which decodes the payload.
+type DecoderRegistry struct {
+	decoders map[string]Decoder // by name and content type
+	auto     []Decoder
+	bindings []decoderBinding
+
+	plainField string        // default field of plain payloads
+	decompress *Decompressor // of payloads without binding
+	maxSize    int           // bytes of decompressed payloads
+}
+
+// NewDecoderRegistry registers the built-in decoders and the bindings of
+// conf.Formats.
+func NewDecoderRegistry(conf *InfluxDBConf) (*DecoderRegistry, error) {
+	r := &DecoderRegistry{
+		decoders:   map[string]Decoder{},
+		plainField: conf.PlainField,
+		maxSize:    conf.DecompressLimit,
+	}
+	decompress, err := NewDecompressor(conf.Compression, r.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	r.decompress = decompress
+
+	// auto-detection tries the decoders in this order
+	r.Register(NewSparkplugDecoder(), true, "sparkplugb")
+	r.Register(&JSONDecoder{UseNumber: useNumber(conf)}, true, "json", "text/json")
+	r.Register(&MsgpackDecoder{}, true, "msgpack", "application/x-msgpack", "application/vnd.msgpack")
+	cbor, err := NewCBORDecoder("")
+	if err != nil {
+		return nil, err
+	}
+	r.Register(cbor, true, "cbor")
+	xml, err := NewXMLDecoder(&FormatConf{})
+	if err != nil {
+		return nil, err
+	}
+	r.Register(xml, true, "xml", "text/xml")
+	plain, err := NewPlainDecoder(r.plainField, &FormatConf{})
+	if err != nil {
+		return nil, err
+	}
+	r.Register(plain, true, "plain")
+
+	lines, err := NewLineProtocolDecoder("")
+	if err != nil {
+		return nil, err
+	}
+	r.Register(lines, false, "influx", "lineprotocol")
+	r.Register(NewSenMLDecoder(&FormatConf{}), false, "senml", "application/senml+cbor")
+	r.Register(NewKeyValueDecoder(&FormatConf{}), false, "kv")
+
+	if err := r.bind(conf.Formats); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register adds a decoder under its content type and the names. If auto is
+// true, the decoder is also tried by auto-detection.
+func (r *DecoderRegistry) Register(d Decoder, auto bool, names ...string) {
+	r.decoders[d.ContentType()] = d
+	for _, name := range names {
+		r.decoders[name] = d
+	}
+	if auto {
+		r.auto = append(r.auto, d)
+	}
+}
+
+// Lookup returns the decoder by name or content type.
+func (r *DecoderRegistry) Lookup(name string) (Decoder, bool) {
+	if d, ok := r.decoders[name]; ok {
+		return d, true
+	}
+	mediaType, _, err := mime.ParseMediaType(name)
+	if err != nil {
+		return nil, false
+	}
+	if d, ok := r.decoders[mediaType]; ok {
+		return d, true
+	}
+	// structured syntax suffix such as application/senml+json
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		if d, ok := r.decoders[mediaType[i+1:]]; ok {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+func (r *DecoderRegistry) bind(formats map[string]*FormatConf) error {
+	// sections are matched in name order
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := formats[name]
+		if len(f.Topic) == 0 {
+			return fmt.Errorf("format %s: topic is empty", name)
+		}
+		b := decoderBinding{filters: f.Topic, decompress: r.decompress}
+		if f.Decoder != "" {
+			d, err := r.formatDecoder(f)
+			if err != nil {
+				return fmt.Errorf("format %s: %s", name, err)
+			}
+			b.decoder = d
+		}
+		if f.Compression != "" {
+			decompress, err := NewDecompressor(f.Compression, r.maxSize)
+			if err != nil {
+				return fmt.Errorf("format %s: %s", name, err)
+			}
+			b.decompress = decompress
+		}
+		r.bindings = append(r.bindings, b)
+	}
+	return nil
+}
+
+// formatDecoder returns the decoder of a format. Decoders with options of the
+// format are created for the format, the others are looked up.
+func (r *DecoderRegistry) formatDecoder(f *FormatConf) (Decoder, error) {
+	switch f.Decoder {
+	case "protobuf":
+		return NewProtobufDecoder(f)
+	case "cbor":
+		return NewCBORDecoder(f.Bytes)
+	case "influx", "lineprotocol":
+		return NewLineProtocolDecoder(f.Precision)
+	case "senml":
+		return NewSenMLDecoder(f), nil
+	case "plain":
+		return NewPlainDecoder(r.plainField, f)
+	case "xml":
+		return NewXMLDecoder(f)
+	case "csv":
+		return NewCSVDecoder(f)
+	case "kv":
+		return NewKeyValueDecoder(f), nil
+	case "binary":
+		return NewBinaryDecoder(f)
+	}
+	d, ok := r.Lookup(f.Decoder)
+	if !ok {
+		return nil, fmt.Errorf("unknown decoder %q", f.Decoder)
+	}
+	return d, nil
+}
+
+// Select returns the decoder bound to the topic of the message or selected
+// by its content type. It returns nil if the decoder must be auto-detected.
+func (r *DecoderRegistry) Select(msg Message) Decoder {
+	if b := r.binding(msg); b != nil && b.decoder != nil {
+		return b.decoder
+	}
+	if msg.ContentType != "" {
+		if d, ok := r.Lookup(msg.ContentType); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+// binding returns the first binding of the topic of the message, or nil.
+func (r *DecoderRegistry) binding(msg Message) *decoderBinding {
+	topic := msg.PublishedTopic()
+	for i, b := range r.bindings {
+		for _, filter := range b.filters {
+			if MatchTopicFilter(filter, topic) {
+				return &r.bindings[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Decode decompresses the payload, decodes the message with the selected or
+// the auto-detected decoder and returns the decoder which was used.
+func (r *DecoderRegistry) Decode(msg Message) ([]Record, Decoder, error) {
+	decompress := r.decompress
+	if b := r.binding(msg); b != nil {
+		decompress = b.decompress
+	}
+	payload, err := decompress.Decompress(msg.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg.Payload = payload
+
+	if d := r.Select(msg); d != nil {
+		records, err := d.Decode(msg)
+		if err != nil {
+			return nil, d, fmt.Errorf("%s: %s", d.ContentType(), err)
+		}
+		return records, d, nil
+	}
+
+	err = fmt.Errorf("no decoder detected")
+	for _, d := range r.auto {
+		if detector, ok := d.(Detector); ok && !detector.Detect(msg) {
+			continue
+		}
+		var records []Record
+		records, err = d.Decode(msg)
+		if err == nil {
+			return records, d, nil
+		}
+		log.Debugf("%s: not %s: %s", msg.PublishedTopic(), d.ContentType(), err)
+	}
+	return nil, nil, err
+}
+
+// fieldRecords returns the fields as a record.
+func fieldRecords(j map[string]interface{}) []Record {
+	return []Record{{Fields: j}}
+}
+
+// objectRecords returns a record for an object, or a record for each object
+// of an array.
+func objectRecords(v interface{}) ([]Record, error) {
+	if j, ok := stringMap(v); ok {
+		return fieldRecords(j), nil
+	}
+	array, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not an object or an array")
+	}
+	ret := make([]Record, 0, len(array))
+	for _, e := range array {
+		if j, ok := stringMap(e); ok {
+			ret = append(ret, Record{Fields: j})
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no objects in the array")
+	}
+	return ret, nil
+}
+
+// JSONDecoder decodes a JSON object or an array of objects.
+type JSONDecoder struct {
+	UseNumber bool // decodes numbers as json.Number instead of float64
+}
+
+func (d *JSONDecoder) ContentType() string {
+	return "application/json"
+}
+
+func (d *JSONDecoder) Detect(msg Message) bool {
+	s := strings.TrimSpace(string(msg.Payload))
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
+
+func (d *JSONDecoder) Decode(msg Message) ([]Record, error) {
+	dec := json.NewDecoder(bytes.NewReader(msg.Payload))
+	if d.UseNumber {
+		dec.UseNumber()
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return objectRecords(v)
+}
+
+// MsgpackDecoder decodes a msgpack map or an array of maps.
+type MsgpackDecoder struct{}
+
+func (d *MsgpackDecoder) ContentType() string {
+	return "application/msgpack"
+}
+
+func (d *MsgpackDecoder) Detect(msg Message) bool {
+	if len(msg.Payload) == 0 {
+		return false
+	}
+	// fixmap, map 16, map 32, fixarray, array 16 or array 32
+	b := msg.Payload[0]
+	return b&0xf0 == 0x80 || b == 0xde || b == 0xdf ||
+		b&0xf0 == 0x90 || b == 0xdc || b == 0xdd
+}
+
+func (d *MsgpackDecoder) Decode(msg Message) ([]Record, error) {
+	var v interface{}
+	if err := msgpack.Unmarshal(msg.Payload, &v); err != nil {
+		return nil, err
+	}
+	return objectRecords(v)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	msgpack "github.com/vmihailenco/msgpack"
+)
+
+func Test_DecoderRegistryAuto(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{})
+	assert.Nil(err)
+
+	records, d, err := r.Decode(Message{Topic: "a", Payload: []byte(` {"x": 1}`)})
+	assert.Nil(err)
+	assert.Equal("application/json", d.ContentType())
+	assert.Equal(float64(1), records[0].Fields["x"])
+
+	payload, err := msgpack.Marshal(map[string]interface{}{"x": 1})
+	assert.Nil(err)
+	records, d, err = r.Decode(Message{Topic: "a", Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/msgpack", d.ContentType())
+	assert.EqualValues(1, records[0].Fields["x"])
+
+	_, d, err = r.Decode(Message{Topic: "a", Payload: []byte(`12`)})
+	assert.Nil(err)
+	assert.Equal("text/plain", d.ContentType())
+
+	_, _, err = r.Decode(Message{Topic: "a", Payload: []byte(`garbage`)})
+	assert.NotNil(err)
+}
+
+func Test_DecoderRegistryBinding(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{
+		Formats: map[string]*FormatConf{
+			"raw": {Topic: []string{"sensors/+/raw"}, Decoder: "plain"},
+		},
+	})
+	assert.Nil(err)
+
+	// bound decoders do not fall back to auto-detection
+	_, _, err = r.Decode(Message{Topic: "sensors/a/raw", Payload: []byte(`{"x": 1}`)})
+	assert.NotNil(err)
+
+	// the binding matches the topic as published
+	msg := Message{Topic: "a/raw", OriginalTopic: "sensors/a/raw", Payload: []byte(`3`)}
+	assert.Equal("text/plain", r.Select(msg).ContentType())
+
+	msg = Message{Topic: "b", ContentType: "application/vnd.foo+json", Payload: []byte(`{"x": 1}`)}
+	assert.Equal("application/json", r.Select(msg).ContentType())
+	assert.Nil(r.Select(Message{Topic: "b"}))
+
+	_, err = NewDecoderRegistry(&InfluxDBConf{
+		Formats: map[string]*FormatConf{
+			"bad": {Topic: []string{"a/#"}, Decoder: "yaml"},
+		},
+	})
+	assert.NotNil(err)
+}
+
+func Test_DecoderRegistryCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{
+		Formats: map[string]*FormatConf{
+			"bulk": {Topic: []string{"gw/+/bulk"}, Compression: CompressionSnappy},
+		},
+	})
+	assert.Nil(err)
+
+	payload := []byte(`{"x": 1}`)
+	records, d, err := r.Decode(Message{Topic: "gw/a/bulk", Payload: snappy.Encode(nil, payload)})
+	assert.Nil(err)
+	assert.Equal("application/json", d.ContentType())
+	assert.Equal(float64(1), records[0].Fields["x"])
+
+	records, _, err = r.Decode(Message{Topic: "gw/a/json", Payload: compressed(t, CompressionGzip, payload)})
+	assert.Nil(err)
+	assert.Equal(float64(1), records[0].Fields["x"])
+
+	_, err = NewDecoderRegistry(&InfluxDBConf{
+		Formats: map[string]*FormatConf{
+			"bad": {Topic: []string{"a/#"}, Compression: "lz4"},
+		},
+	})
+	assert.NotNil(err)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -12,6 +13,14 @@ import (
 type MqttSeriesEncoder struct {
 	Config   *InfluxDBConf
 	matchers []TopicMatcher
+	verifier *Verifier
+	decoders *DecoderRegistry
+	batch    *Batcher
+	time     *TimeParser
+	flatten  *Flattener
+	numbers  *NumberConverter
+	schema   *Schema
+	static   map[string]string
 }
 
 func createTopicMatcher(topicMap []string) []TopicMatcher {
@@ -24,47 +33,178 @@ func createTopicMatcher(topicMap []string) []TopicMatcher {
 	return m
 }
 
-func NewMqttSeriesEncoder(conf *InfluxDBConf) *MqttSeriesEncoder {
+func NewMqttSeriesEncoder(conf *InfluxDBConf) (*MqttSeriesEncoder, error) {
+	verifier, err := NewVerifier(conf)
+	if err != nil {
+		return nil, err
+	}
+	decoders, err := NewDecoderRegistry(conf)
+	if err != nil {
+		return nil, err
+	}
+	timeParser, err := NewTimeParser(conf)
+	if err != nil {
+		return nil, err
+	}
+	flatten, err := NewFlattener(conf)
+	if err != nil {
+		return nil, err
+	}
+	numbers, err := NewNumberConverter(conf)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := NewSchema(conf)
+	if err != nil {
+		return nil, err
+	}
+	static, err := staticTags(conf.StaticTag)
+	if err != nil {
+		return nil, err
+	}
 	return &MqttSeriesEncoder{
 		Config:   conf,
 		matchers: createTopicMatcher(conf.TopicMap),
+		verifier: verifier,
+		decoders: decoders,
+		batch:    NewBatcher(conf),
+		time:     timeParser,
+		flatten:  flatten,
+		numbers:  numbers,
+		schema:   schema,
+		static:   static,
+	}, nil
+}
+
+// staticTags parses "name=value" tags.
+func staticTags(conf []string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, tag := range conf {
+		v := strings.SplitN(tag, "=", 2)
+		if len(v) != 2 || v[0] == "" {
+			return nil, fmt.Errorf("static tag must be 'name=value': %s", tag)
+		}
+		tags[v[0]] = v[1]
 	}
+	return tags, nil
 }
 
-func (ifc *MqttSeriesEncoder) Encode(msg Message) *write.Point {
+// Encode converts the message to points. The records of a payload with
+// several timestamps and the elements of a batch payload become separate
+// points.
+func (ifc *MqttSeriesEncoder) Encode(msg Message) []*write.Point {
 	now := time.Now()
 
 	if msg.Topic == "" && len(msg.Payload) == 0 {
 		return nil
 	}
-	j, err := MsgParse(msg.Payload)
+	msg, err := ifc.verifier.Verify(msg)
+	if err != nil {
+		// a message which is not verified is not written at all
+		log.Warnf("%s: rejected: %s", msg.PublishedTopic(), err)
+		stats.unverified.Add(1)
+		return nil
+	}
+	records, err := ifc.decode(msg)
 	if err != nil {
 		log.Warn(err)
 		return nil
 	}
 
-	name := ifc.Config.Series
-	if len(name) == 0 {
-		name = strings.Replace(msg.Topic, "/", ".", -1)
+	batch := []Record{}
+	for _, r := range records {
+		batch = append(batch, ifc.batch.Split(r)...)
+	}
+
+	points := []*write.Point{}
+	for _, r := range batch {
+		if err := ifc.time.Extract(&r, now); err != nil {
+			log.Warnf("%s: %s", msg.PublishedTopic(), err)
+			continue
+		}
+		r.Fields = ifc.flatten.Flatten(renameTime(r.Fields))
+		r.Fields = ifc.numbers.Convert(r.Fields)
+		name := ifc.name(msg, r)
+		tags := ifc.tags(msg, r)
+		if err := ifc.schema.Apply(name, r.Fields); err != nil {
+			// a message which does not fit is not written at all
+			log.Warnf("%s: rejected: %s", msg.PublishedTopic(), err)
+			stats.rejected.Add(1)
+			return nil
+		}
+		if len(r.Fields) == 0 {
+			continue
+		}
+		t := r.Time
+		if t.IsZero() {
+			t = now
+		}
+		points = append(points, influxdb2.NewPoint(name, tags, r.Fields, t))
+	}
+	return points
+}
+
+// decode decodes the payload into records.
+func (ifc *MqttSeriesEncoder) decode(msg Message) ([]Record, error) {
+	records, d, err := ifc.decoders.Decode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", msg.PublishedTopic(), err)
+	}
+	log.Debugf("%s: decoded as %s", msg.PublishedTopic(), d.ContentType())
+	return records, nil
+}
+
+// name returns the measurement of a record: the configured series, the
+// measurement of the payload or the topic.
+func (ifc *MqttSeriesEncoder) name(msg Message, r Record) string {
+	if len(ifc.Config.Series) > 0 {
+		return ifc.Config.Series
+	}
+	if r.Measurement != "" {
+		return r.Measurement
 	}
+	return strings.Replace(msg.Topic, "/", ".", -1)
+}
 
+// tags returns the tags of a record. Fields listed in TagsAttributes are
+// moved from the record to the tags.
+func (ifc *MqttSeriesEncoder) tags(msg Message, r Record) map[string]string {
 	tags := map[string]string{}
 
+	for tag, tagVal := range ifc.static {
+		tags[tag] = tagVal
+	}
+
 	// Store default tag attributes
 	if !ifc.Config.NoTopicTag {
 		tags["topic"] = msg.Topic
+		if ifc.Config.OriginalTopicTag && msg.OriginalTopic != "" {
+			tags["topic"] = msg.OriginalTopic
+		}
+	}
+
+	// Tags decoded from the payload
+	for tag, tagVal := range r.Tags {
+		tags[tag] = tagVal
 	}
 
 	// Transform user-defined JSON fields to tags
 	for _, tag := range ifc.Config.TagsAttributes {
-		if v, ok := j[tag]; ok {
+		if v, ok := r.Fields[tag]; ok {
 			if tagVal, ok := v.(string); ok {
 				tags[tag] = tagVal
-				delete(j, tag)
+				delete(r.Fields, tag)
 			}
 		}
 	}
 
+	// Transform MQTT v5 user properties to tags
+	for _, tag := range ifc.Config.PropertyTags {
+		if tagVal, ok := msg.UserProperties[tag]; ok {
+			tags[tag] = tagVal
+		}
+	}
+
 	// Append first match from TopicMap
 	for _, m := range ifc.matchers {
 		b, v := m.Match(msg.Topic)
@@ -76,5 +216,5 @@ func (ifc *MqttSeriesEncoder) Encode(msg Message) *write.Point {
 		}
 	}
 
-	return influxdb2.NewPoint(name, tags, j, now)
+	return tags
 }
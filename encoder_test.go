@@ -17,10 +17,12 @@ func Test_Encoder(t *testing.T) {
 		Db:             "db",
 		TagsAttributes: []string{},
 	}
-	coder := NewMqttSeriesEncoder(conf)
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
 
-	ret := coder.Encode(msg)
-	assert.NotNil(ret)
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	ret := points[0]
 	assert.Equal("a.b", ret.Name())
 	e := []*lp.Field{
 		{
@@ -56,10 +58,12 @@ func Test_Tags(t *testing.T) {
 		Db:             "db",
 		TagsAttributes: []string{"loc"},
 	}
-	coder := NewMqttSeriesEncoder(conf)
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
 
-	ret := coder.Encode(msg)
-	assert.NotNil(ret)
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	ret := points[0]
 	assert.Equal("a.b", ret.Name())
 	e := []*lp.Field{
 		{
@@ -122,10 +126,12 @@ func Test_TagMapping(t *testing.T) {
 		NoTopicTag: true,
 		Series:     "data",
 	}
-	coder := NewMqttSeriesEncoder(conf)
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
 
-	ret := coder.Encode(msg)
-	assert.NotNil(ret)
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	ret := points[0]
 	assert.Equal("data", ret.Name())
 	e := []*lp.Field{
 		{
@@ -176,3 +182,91 @@ func Test_TagMapping(t *testing.T) {
 		assert.True(found, "test tag %d is not in actual tags", i)
 	}
 }
+
+func Test_PropertyTags(t *testing.T) {
+	assert := assert.New(t)
+	msg := Message{
+		Topic:       "a/b",
+		Payload:     []byte(`{"x": 1}`),
+		ContentType: "application/json",
+		UserProperties: map[string]string{
+			"site":  "tokyo",
+			"other": "ignored",
+		},
+	}
+	conf := &InfluxDBConf{
+		Db:           "db",
+		NoTopicTag:   true,
+		PropertyTags: []string{"site"},
+	}
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
+
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	ret := points[0]
+	assert.Equal([]*lp.Tag{
+		{
+			Key:   "site",
+			Value: "tokyo",
+		},
+	}, ret.TagList())
+}
+
+func Test_OriginalTopicTag(t *testing.T) {
+	assert := assert.New(t)
+	msg := Message{
+		Topic:         "a/b",
+		OriginalTopic: "site/A/B",
+		Payload:       []byte(`{"x": 1}`),
+	}
+	conf := &InfluxDBConf{
+		Db:               "db",
+		OriginalTopicTag: true,
+	}
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
+
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	ret := points[0]
+	assert.Equal("a.b", ret.Name())
+	assert.Equal([]*lp.Tag{
+		{
+			Key:   "topic",
+			Value: "site/A/B",
+		},
+	}, ret.TagList())
+}
+
+func Test_EncodeNested(t *testing.T) {
+	assert := assert.New(t)
+	msg := Message{
+		Topic:   "a/b",
+		Payload: []byte(`{"env": {"temp": 21, "loc": "top"}, "list": [1, 2]}`),
+	}
+	conf := &InfluxDBConf{
+		NoTopicTag:     true,
+		TagsAttributes: []string{"env_loc"},
+	}
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
+
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	fields := map[string]interface{}{}
+	for _, f := range points[0].FieldList() {
+		fields[f.Key] = f.Value
+	}
+	assert.Equal(map[string]interface{}{
+		"env_temp": float64(21),
+		"list_0":   float64(1),
+		"list_1":   float64(2),
+	}, fields)
+	assert.Equal([]*lp.Tag{
+		{
+			Key:   "env_loc",
+			Value: "top",
+		},
+	}, points[0].TagList())
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Field types of InfluxDB
+const (
+	FieldFloat  = "float"
+	FieldInt    = "int"
+	FieldUint   = "uint"
+	FieldBool   = "bool"
+	FieldString = "string"
+)
+
+// Number modes
+const (
+	NumbersDecoded  = ""         // numbers keep the type of the decoder
+	NumbersFloat    = "float"    // all numbers are floats
+	NumbersInteger  = "integer"  // all numbers are integers, fractions are truncated
+	NumbersUnsigned = "unsigned" // all numbers are unsigned integers, negative numbers are dropped
+)
+
+// NumberConverter keeps the type of each field the same, whichever decoder
+// and publisher the payload comes from.
+type NumberConverter struct {
+	mode  string
+	types map[string]string // field type overrides by field name
+}
+
+func NewNumberConverter(conf *InfluxDBConf) (*NumberConverter, error) {
+	c := &NumberConverter{
+		mode:  conf.Numbers,
+		types: map[string]string{},
+	}
+	switch c.mode {
+	case NumbersDecoded, NumbersFloat, NumbersInteger, NumbersUnsigned:
+	default:
+		return nil, fmt.Errorf("unknown numbers mode %q", c.mode)
+	}
+
+	for _, s := range conf.FieldType {
+		v := strings.Fields(s)
+		if len(v) != 2 {
+			return nil, fmt.Errorf("field type must be 'name type': %s", s)
+		}
+		if !validFieldType(v[1]) {
+			return nil, fmt.Errorf("field %s: unknown type %q", v[0], v[1])
+		}
+		c.types[v[0]] = v[1]
+	}
+	return c, nil
+}
+
+// useNumber reports whether JSON numbers must be decoded as json.Number to
+// keep integers.
+func useNumber(conf *InfluxDBConf) bool {
+	return conf.Numbers == NumbersInteger || conf.Numbers == NumbersUnsigned
+}
+
+// Convert converts the numbers of the fields to the mode and the fields with
+// a type override to their type. Fields which can not be converted are
+// dropped.
+func (c *NumberConverter) Convert(fields map[string]interface{}) map[string]interface{} {
+	for key, value := range fields {
+		if typ, ok := c.types[key]; ok {
+			v, err := convertField(value, typ)
+			if err != nil {
+				log.Debugf("field %s: %s", key, err)
+				delete(fields, key)
+				continue
+			}
+			fields[key] = v
+			continue
+		}
+		v, err := c.convertNumber(value)
+		if err != nil {
+			log.Warnf("field %s: %s", key, err)
+			delete(fields, key)
+			continue
+		}
+		fields[key] = v
+	}
+	return fields
+}
+
+// convertNumber converts a number to the mode, so that a field has the same
+// type whatever its value is. Other values are returned as they are.
+func (c *NumberConverter) convertNumber(value interface{}) (interface{}, error) {
+	if c.mode == NumbersDecoded {
+		return value, nil
+	}
+	if n, ok := value.(json.Number); ok {
+		value = jsonNumber(n)
+	}
+	r := reflect.ValueOf(value)
+	switch c.mode {
+	case NumbersFloat:
+		if f, ok := number(value); ok {
+			return f, nil
+		}
+	case NumbersInteger:
+		switch r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return r.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if r.Uint() <= math.MaxInt64 {
+				return int64(r.Uint()), nil
+			}
+		case reflect.Float32, reflect.Float64:
+			f := math.Trunc(r.Float())
+			if f >= math.MinInt64 && f < math.MaxInt64 {
+				return int64(f), nil
+			}
+		default:
+			return value, nil
+		}
+		return nil, fmt.Errorf("%v is out of the integer range", value)
+	case NumbersUnsigned:
+		switch r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if r.Int() >= 0 {
+				return uint64(r.Int()), nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return r.Uint(), nil
+		case reflect.Float32, reflect.Float64:
+			f := math.Trunc(r.Float())
+			if f >= 0 && f < math.MaxUint64 {
+				return uint64(f), nil
+			}
+		default:
+			return value, nil
+		}
+		return nil, fmt.Errorf("%v is out of the unsigned range", value)
+	}
+	return value, nil
+}
+
+func validFieldType(typ string) bool {
+	switch typ {
+	case FieldFloat, FieldInt, FieldUint, FieldBool, FieldString:
+		return true
+	}
+	return false
+}
+
+// convertField converts a decoded value to the field type.
+func convertField(value interface{}, typ string) (interface{}, error) {
+	if n, ok := value.(json.Number); ok {
+		if typ == FieldString {
+			return string(n), nil
+		}
+		value = jsonNumber(n)
+	}
+
+	switch typ {
+	case FieldString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		if b, ok := value.([]byte); ok {
+			return string(b), nil
+		}
+		return fmt.Sprint(value), nil
+	case FieldBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+		if f, ok := number(value); ok {
+			return f != 0, nil
+		}
+	case FieldFloat:
+		if s, ok := value.(string); ok {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+		if b, ok := value.(bool); ok {
+			return boolNumber(b), nil
+		}
+		if f, ok := number(value); ok {
+			return f, nil
+		}
+	case FieldInt:
+		if s, ok := value.(string); ok {
+			return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		}
+		if b, ok := value.(bool); ok {
+			return int64(boolNumber(b)), nil
+		}
+		r := reflect.ValueOf(value)
+		switch r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return r.Int(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if r.Uint() <= math.MaxInt64 {
+				return int64(r.Uint()), nil
+			}
+		case reflect.Float32, reflect.Float64:
+			f := r.Float()
+			if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+				return int64(f), nil
+			}
+		}
+	case FieldUint:
+		if s, ok := value.(string); ok {
+			return strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		}
+		if b, ok := value.(bool); ok {
+			return uint64(boolNumber(b)), nil
+		}
+		r := reflect.ValueOf(value)
+		switch r.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if r.Int() >= 0 {
+				return uint64(r.Int()), nil
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return r.Uint(), nil
+		case reflect.Float32, reflect.Float64:
+			f := r.Float()
+			if f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+				return uint64(f), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("can not convert %v to %s", value, typ)
+}
+
+// jsonNumber returns n as int64, as uint64 if it is too large, or as float64.
+func jsonNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func boolNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
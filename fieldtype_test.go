@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NumberConverter(t *testing.T) {
+	assert := assert.New(t)
+
+	fields := func() map[string]interface{} {
+		return map[string]interface{}{
+			"j": json.Number("3"),
+			"n": json.Number("-3"),
+			"f": json.Number("1.5"),
+			"m": int8(4),
+			"s": "x",
+		}
+	}
+	cases := []struct {
+		numbers  string
+		expected map[string]interface{}
+	}{
+		{"float", map[string]interface{}{
+			"j": float64(3), "n": float64(-3), "f": 1.5, "m": float64(4), "s": "x",
+		}},
+		{"integer", map[string]interface{}{
+			"j": int64(3), "n": int64(-3), "f": int64(1), "m": int64(4), "s": "x",
+		}},
+		{"unsigned", map[string]interface{}{
+			"j": uint64(3), "f": uint64(1), "m": uint64(4), "s": "x",
+		}},
+	}
+	for _, c := range cases {
+		conv, err := NewNumberConverter(&InfluxDBConf{Numbers: c.numbers})
+		assert.Nil(err)
+		assert.Equal(c.expected, conv.Convert(fields()), c.numbers)
+	}
+
+	_, err := NewNumberConverter(&InfluxDBConf{Numbers: "decimal"})
+	assert.NotNil(err)
+}
+
+func Test_NumberConverterTypes(t *testing.T) {
+	assert := assert.New(t)
+
+	// the values of a field keep their type across zero and fractions
+	values := []interface{}{
+		json.Number("1"), json.Number("-1"), json.Number("0.5"), json.Number("-0.5"),
+		int64(2), int64(-2), 2.5, -2.5, uint64(math.MaxUint64), math.NaN(),
+	}
+	integer, err := NewNumberConverter(&InfluxDBConf{Numbers: "integer"})
+	assert.Nil(err)
+	unsigned, err := NewNumberConverter(&InfluxDBConf{Numbers: "unsigned"})
+	assert.Nil(err)
+
+	ints := []interface{}{}
+	uints := []interface{}{}
+	for _, v := range values {
+		if i, ok := integer.Convert(map[string]interface{}{"v": v})["v"]; ok {
+			ints = append(ints, i)
+		}
+		if u, ok := unsigned.Convert(map[string]interface{}{"v": v})["v"]; ok {
+			uints = append(uints, u)
+		}
+	}
+	assert.Equal([]interface{}{
+		int64(1), int64(-1), int64(0), int64(0), int64(2), int64(-2), int64(2), int64(-2),
+	}, ints)
+	assert.Equal([]interface{}{
+		uint64(1), uint64(0), uint64(0), uint64(2), uint64(2), uint64(math.MaxUint64),
+	}, uints)
+}
+
+func Test_FieldTypeOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	conv, err := NewNumberConverter(&InfluxDBConf{
+		Numbers:   "float",
+		FieldType: []string{"count uint", "code string", "on bool", "level int", "bad int"},
+	})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"count": uint64(12),
+		"code":  "404",
+		"on":    true,
+		"level": int64(-2),
+		"x":     float64(1),
+	}, conv.Convert(map[string]interface{}{
+		"count": float64(12),
+		"code":  json.Number("404"),
+		"on":    "true",
+		"level": "-2",
+		"bad":   1.5,
+		"x":     int64(1),
+	}))
+
+	_, err = NewNumberConverter(&InfluxDBConf{FieldType: []string{"x decimal"}})
+	assert.NotNil(err)
+	_, err = NewNumberConverter(&InfluxDBConf{FieldType: []string{"x"}})
+	assert.NotNil(err)
+}
+
+func Test_EncodeIntegers(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{Numbers: "integer", NoTopicTag: true})
+	assert.Nil(err)
+	points := coder.Encode(Message{Topic: "a", Payload: []byte(`{"x": 9007199254740993, "y": 2.5}`)})
+	assert.Equal(1, len(points))
+	fields := map[string]interface{}{}
+	for _, f := range points[0].FieldList() {
+		fields[f.Key] = f.Value
+	}
+	assert.Equal(map[string]interface{}{"x": int64(9007199254740993), "y": int64(2)}, fields)
+}
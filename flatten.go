@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	DefaultFlattenSeparator = "_"
+
+	FlattenNested = "flatten" // nested objects and arrays become fields
+	FlattenDrop   = "drop"    // nested objects and arrays are dropped
+
+	ArrayIndex    = "index"    // env_values_0
+	ArrayBrackets = "brackets" // env_values[0]
+	ArrayDrop     = "drop"     // arrays are dropped
+)
+
+// Flattener turns nested objects and arrays of decoded fields into fields
+// with scalar values, so that `{"env":{"temp":21}}` becomes `env_temp=21`.
+type Flattener struct {
+	mode      string
+	separator string
+	maxDepth  int // 0 is unlimited
+	arrays    string
+}
+
+func NewFlattener(conf *InfluxDBConf) (*Flattener, error) {
+	f := &Flattener{
+		mode:      conf.Flatten,
+		separator: conf.FlattenSeparator,
+		maxDepth:  conf.FlattenMaxDepth,
+		arrays:    conf.FlattenArrays,
+	}
+	if f.mode == "" {
+		f.mode = FlattenNested
+	}
+	if f.separator == "" {
+		f.separator = DefaultFlattenSeparator
+	}
+	if f.arrays == "" {
+		f.arrays = ArrayIndex
+	}
+
+	switch f.mode {
+	case FlattenNested, FlattenDrop:
+	default:
+		return nil, fmt.Errorf("unknown flatten mode %q", f.mode)
+	}
+	switch f.arrays {
+	case ArrayIndex, ArrayBrackets, ArrayDrop:
+	default:
+		return nil, fmt.Errorf("unknown flatten arrays %q", f.arrays)
+	}
+	if f.maxDepth < 0 {
+		return nil, fmt.Errorf("flatten max depth is negative")
+	}
+	return f, nil
+}
+
+// Flatten returns the fields with scalar values. Null values and values nested
+// deeper than the max depth are dropped.
+func (f *Flattener) Flatten(fields map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		f.flatten(ret, key, value, 1)
+	}
+	return ret
+}
+
+func (f *Flattener) flatten(ret map[string]interface{}, key string, value interface{}, depth int) {
+	switch v := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		if !f.nested(depth) {
+			return
+		}
+		for k, e := range v {
+			f.flatten(ret, key+f.separator+k, e, depth+1)
+		}
+	case map[interface{}]interface{}:
+		// nested msgpack maps
+		if !f.nested(depth) {
+			return
+		}
+		for k, e := range v {
+			f.flatten(ret, key+f.separator+fmt.Sprint(k), e, depth+1)
+		}
+	case []interface{}:
+		if !f.nested(depth) || f.arrays == ArrayDrop {
+			return
+		}
+		for i, e := range v {
+			f.flatten(ret, f.indexKey(key, i), e, depth+1)
+		}
+	default:
+		ret[key] = value
+	}
+}
+
+// nested reports whether the values below depth are kept.
+func (f *Flattener) nested(depth int) bool {
+	if f.mode == FlattenDrop {
+		return false
+	}
+	return f.maxDepth == 0 || depth < f.maxDepth
+}
+
+func (f *Flattener) indexKey(key string, i int) string {
+	if f.arrays == ArrayBrackets {
+		return key + "[" + strconv.Itoa(i) + "]"
+	}
+	return key + f.separator + strconv.Itoa(i)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Flatten(t *testing.T) {
+	assert := assert.New(t)
+
+	fields := func() map[string]interface{} {
+		return map[string]interface{}{
+			"a": float64(1),
+			"n": nil,
+			"env": map[string]interface{}{
+				"temp": float64(21),
+				"deep": map[string]interface{}{"x": "y"},
+			},
+			"v": []interface{}{float64(1), map[interface{}]interface{}{"k": true}},
+		}
+	}
+
+	cases := []struct {
+		conf     InfluxDBConf
+		expected map[string]interface{}
+	}{
+		{InfluxDBConf{}, map[string]interface{}{
+			"a": float64(1), "env_temp": float64(21), "env_deep_x": "y",
+			"v_0": float64(1), "v_1_k": true,
+		}},
+		{InfluxDBConf{FlattenSeparator: ".", FlattenArrays: "brackets"}, map[string]interface{}{
+			"a": float64(1), "env.temp": float64(21), "env.deep.x": "y",
+			"v[0]": float64(1), "v[1].k": true,
+		}},
+		{InfluxDBConf{FlattenMaxDepth: 2}, map[string]interface{}{
+			"a": float64(1), "env_temp": float64(21), "v_0": float64(1),
+		}},
+		{InfluxDBConf{FlattenArrays: "drop"}, map[string]interface{}{
+			"a": float64(1), "env_temp": float64(21), "env_deep_x": "y",
+		}},
+		{InfluxDBConf{Flatten: "drop"}, map[string]interface{}{
+			"a": float64(1),
+		}},
+	}
+	for i, c := range cases {
+		f, err := NewFlattener(&c.conf)
+		assert.Nil(err)
+		assert.Equal(c.expected, f.Flatten(fields()), "case %d", i)
+	}
+
+	_, err := NewFlattener(&InfluxDBConf{Flatten: "json"})
+	assert.NotNil(err)
+	_, err = NewFlattener(&InfluxDBConf{FlattenArrays: "join"})
+	assert.NotNil(err)
+}
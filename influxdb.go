@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -13,31 +14,58 @@ import (
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
 const (
 	DefaultTick = 1
 	PingTimeout = 500 * time.Millisecond
+
+	MaxWriteRetryInterval = time.Minute
 )
 
 type InfluxDBConf struct {
-	Hostname       string
-	Port           int
-	Url            string
-	Db             string
-	Token          string
-	Tick           int
-	UDP            bool
-	Debug          string
-	TagsAttributes []string
-	TopicMap       []string // maps the end of the mqtt topic to tags `weather/{loc}/{sensor}`
-	NoTopicTag     bool     // does not forward the topic as tag
-	Series         string   // override the series name instead of topic mapping
-	CaCerts        []string
-	Scheme         string
-	Insecure       bool // skips certificate validation
-	Bucket         string
-	Org            string
+	Hostname         string
+	Port             int
+	Url              string
+	Db               string
+	Token            string
+	Tick             int
+	UDP              bool
+	Debug            string
+	TagsAttributes   []string
+	StaticTag        []string               // "name=value" tags added to every point
+	PropertyTags     []string               // MQTT v5 user properties stored as tags
+	Formats          map[string]*FormatConf // filled from [mqforward-format "name"] sections
+	PlainField       string                 // field of plain payloads, "value" by default
+	Compression      string                 // "auto" (default), "none", "gzip", "zlib", "zstd" or "snappy"
+	DecompressLimit  int                    // bytes of a decompressed payload, 16 MiB by default
+	TopicMap         []string               // maps the end of the mqtt topic to tags `weather/{loc}/{sensor}`
+	NoTopicTag       bool                   // does not forward the topic as tag
+	OriginalTopicTag bool                   // the topic tag is the topic as published instead of the rewritten one
+	Series           string                 // override the series name instead of topic mapping
+	BatchPath        string                 // dot separated path to the array of a batch payload
+	BatchTime        string                 // field of a batch element with its timestamp
+	TimeField        string                 // field with the timestamp of the point, "time" by default
+	TimeFormat       string                 // rfc3339, unix, unix_ms, unix_us, unix_ns or a Go time layout
+	TimeZone         string                 // location of time layouts without time zone, UTC by default
+	TimeMaxFuture    int                    // seconds a timestamp may be ahead of the receive time, 0 is unlimited
+	TimeMaxPast      int                    // seconds a timestamp may be behind the receive time, 0 is unlimited
+	Numbers          string                 // "float", "integer" or "unsigned" number types, as decoded by default
+	FieldType        []string               // "name type" overrides the type of a field: float, int, uint, bool or string
+	Schemas          map[string]*SchemaConf // filled from [mqforward-schema "measurement"] sections
+	SchemaLearn      bool                   // enforces the first type seen of the fields without schema
+	Verify           map[string]*VerifyConf // filled from [mqforward-verify "name"] sections
+	Flatten          string                 // "flatten" (default) or "drop" nested objects and arrays
+	FlattenSeparator string                 // joins the keys of nested fields, "_" by default
+	FlattenMaxDepth  int                    // nesting levels kept as fields, 0 is unlimited
+	FlattenArrays    string                 // array element keys: "index" (default), "brackets" or "drop"
+	CaCerts          []string
+	Scheme           string
+	Insecure         bool // skips certificate validation
+	Bucket           string
+	Org              string
 }
 
 type InfluxDBClient struct {
@@ -47,7 +75,8 @@ type InfluxDBClient struct {
 
 	ifChan chan Message
 
-	write api.WriteAPI
+	write    api.WriteAPI
+	blocking api.WriteAPIBlocking // used for messages acknowledged after write
 }
 
 func LoadCertPool(conf InfluxDBConf) *x509.CertPool {
@@ -111,12 +140,19 @@ func NewInfluxDBClient(conf InfluxDBConf, ifChan chan Message) (*InfluxDBClient,
 		tick = DefaultTick
 	}
 
+	coder, err := NewMqttSeriesEncoder(&conf)
+	if err != nil {
+		return nil, err
+	}
+
 	ifc := InfluxDBClient{
 		Client: client,
-		Coder:  NewMqttSeriesEncoder(&conf),
+		Coder:  coder,
 		Config: conf,
 		ifChan: ifChan,
 		write:  client.WriteAPI(conf.Org, conf.Bucket),
+
+		blocking: client.WriteAPIBlocking(conf.Org, conf.Bucket),
 	}
 
 	return &ifc, nil
@@ -124,12 +160,69 @@ func NewInfluxDBClient(conf InfluxDBConf, ifChan chan Message) (*InfluxDBClient,
 
 // Start start sending
 func (ifc *InfluxDBClient) Start() error {
+	go ifc.logErrors()
 	for {
 		msg := <-ifc.ifChan
-		point := ifc.Coder.Encode(msg)
-		if point == nil {
+		points := ifc.Coder.Encode(msg)
+		if len(points) == 0 {
+			// nothing to write, do not get it redelivered
+			stats.dropped.Add(1)
+			msg.ack()
 			continue
 		}
-		ifc.write.WritePoint(point)
+		if msg.Ack == nil {
+			for _, point := range points {
+				ifc.write.WritePoint(point)
+			}
+			stats.written.Add(1)
+			continue
+		}
+		if ifc.writeAcked(points) {
+			stats.written.Add(1)
+		} else {
+			stats.dropped.Add(1)
+		}
+		msg.ack()
+	}
+}
+
+// logErrors logs the errors of asynchronous writes.
+func (ifc *InfluxDBClient) logErrors() {
+	for err := range ifc.write.Errors() {
+		stats.writeErrors.Add(1)
+		log.Errorf("influxdb write failed: %s", err)
+	}
+}
+
+// Ping reports whether InfluxDB is reachable.
+func (ifc *InfluxDBClient) Ping() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), PingTimeout)
+	defer cancel()
+	ok, err := ifc.Client.Ping(ctx)
+	return err == nil && ok
+}
+
+// writeAcked writes the points synchronously. It retries until the write
+// succeeds or InfluxDB rejects the points, so that the message is
+// acknowledged only after it is stored. It returns false if the points were
+// rejected.
+func (ifc *InfluxDBClient) writeAcked(points []*write.Point) bool {
+	wait := time.Second
+	for {
+		err := ifc.blocking.WritePoint(context.Background(), points...)
+		if err == nil {
+			return true
+		}
+		var herr *http.Error
+		if errors.As(err, &herr) && herr.StatusCode >= 400 && herr.StatusCode < 500 &&
+			herr.StatusCode != 429 {
+			log.Errorf("influxdb rejected points: %s", err)
+			return false
+		}
+		log.Errorf("influxdb write failed, retry in %s: %s", wait, err)
+		time.Sleep(wait)
+		if wait < MaxWriteRetryInterval {
+			wait *= 2
+		}
 	}
 }
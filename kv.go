@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyValueDecoder decodes `temp=21.5,hum=40` payloads. Values are numbers,
+// or strings which may be quoted to contain the separator.
+type KeyValueDecoder struct {
+	separator string // between pairs, any white space if " "
+}
+
+func NewKeyValueDecoder(f *FormatConf) *KeyValueDecoder {
+	d := &KeyValueDecoder{separator: f.Separator}
+	if d.separator == "" {
+		d.separator = ","
+	}
+	return d
+}
+
+func (d *KeyValueDecoder) ContentType() string {
+	return "text/x-key-value"
+}
+
+func (d *KeyValueDecoder) Decode(msg Message) ([]Record, error) {
+	fields := map[string]interface{}{}
+	for _, pair := range d.split(string(msg.Payload)) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		v := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(v[0])
+		if len(v) != 2 || key == "" {
+			return nil, fmt.Errorf("not a key=value pair: %s", pair)
+		}
+		value := strings.TrimSpace(v[1])
+		if u, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			fields[key] = u
+			continue
+		}
+		fields[key] = textValue(value)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no key=value pairs")
+	}
+	return fieldRecords(fields), nil
+}
+
+// split splits the payload into pairs at the separators outside of quotes.
+func (d *KeyValueDecoder) split(s string) []string {
+	pairs := []string{}
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' && (i == 0 || s[i-1] != '\\'):
+			quoted = !quoted
+		case quoted:
+		case d.separator == " " && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r'),
+			d.separator != " " && strings.HasPrefix(s[i:], d.separator):
+			pairs = append(pairs, s[start:i])
+			if d.separator != " " {
+				i += len(d.separator) - 1
+			}
+			start = i + 1
+		}
+	}
+	return append(pairs, s[start:])
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_KeyValueDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	d := NewKeyValueDecoder(&FormatConf{})
+	records, err := d.Decode(Message{Payload: []byte(`temp=21.5, hum=40,state="a, b",mode=auto`)})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"temp": 21.5, "hum": int64(40), "state": "a, b", "mode": "auto",
+	}, records[0].Fields)
+
+	d = NewKeyValueDecoder(&FormatConf{Separator: " "})
+	records, err = d.Decode(Message{Payload: []byte("temp=21.5  hum=40\n")})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"temp": 21.5, "hum": int64(40)}, records[0].Fields)
+
+	_, err = d.Decode(Message{Payload: []byte("temp")})
+	assert.NotNil(err)
+	_, err = d.Decode(Message{Payload: []byte(" ")})
+	assert.NotNil(err)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	lp "github.com/influxdata/line-protocol"
+)
+
+// Time precisions of line protocol timestamps
+var linePrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// LineProtocolDecoder decodes InfluxDB line protocol. Every line is a record
+// with its own measurement, tags and timestamp.
+type LineProtocolDecoder struct {
+	precision time.Duration
+}
+
+func NewLineProtocolDecoder(precision string) (*LineProtocolDecoder, error) {
+	p, ok := linePrecisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("line protocol: unknown precision %q", precision)
+	}
+	return &LineProtocolDecoder{precision: p}, nil
+}
+
+func (d *LineProtocolDecoder) ContentType() string {
+	return "application/vnd.influxdb.line-protocol"
+}
+
+// Decode parses all lines. The message is invalid if any line is invalid.
+func (d *LineProtocolDecoder) Decode(msg Message) ([]Record, error) {
+	parser := lp.NewStreamParser(bytes.NewReader(msg.Payload))
+	parser.SetTimePrecision(d.precision)
+	// lines without timestamp get the receive time
+	parser.SetTimeFunc(func() time.Time { return time.Time{} })
+
+	ret := []Record{}
+	for {
+		m, err := parser.Next()
+		if err == lp.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := Record{
+			Measurement: m.Name(),
+			Time:        m.Time(),
+			Tags:        map[string]string{},
+			Fields:      map[string]interface{}{},
+		}
+		for _, t := range m.TagList() {
+			r.Tags[t.Key] = t.Value
+		}
+		for _, f := range m.FieldList() {
+			r.Fields[f.Key] = f.Value
+		}
+		ret = append(ret, r)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no lines")
+	}
+	return ret, nil
+}
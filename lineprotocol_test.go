@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	lp "github.com/influxdata/line-protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LineProtocolDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewLineProtocolDecoder("")
+	assert.Nil(err)
+	records, err := d.Decode(Message{Payload: []byte(
+		"cpu,host=a usage=0.5,count=3i 1600000000000000000\n" +
+			"mem,host=a free=12u,ok=true,state=\"up\"\n")})
+	assert.Nil(err)
+	assert.Equal(2, len(records))
+	assert.Equal(Record{
+		Measurement: "cpu",
+		Time:        time.Unix(1600000000, 0),
+		Tags:        map[string]string{"host": "a"},
+		Fields:      map[string]interface{}{"usage": 0.5, "count": int64(3)},
+	}, records[0])
+	assert.True(records[1].Time.IsZero())
+	assert.Equal(map[string]interface{}{"free": uint64(12), "ok": true, "state": "up"}, records[1].Fields)
+
+	d, err = NewLineProtocolDecoder("s")
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: []byte("cpu usage=1 1600000000")})
+	assert.Nil(err)
+	assert.Equal(time.Unix(1600000000, 0), records[0].Time)
+
+	_, err = d.Decode(Message{Payload: []byte("cpu usage=1\ncpu usage")})
+	assert.NotNil(err)
+	_, err = d.Decode(Message{Payload: []byte("\n")})
+	assert.NotNil(err)
+	_, err = NewLineProtocolDecoder("h")
+	assert.NotNil(err)
+}
+
+func Test_EncodeLineProtocol(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		NoTopicTag: true,
+		StaticTag:  []string{"site=tokyo"},
+		TopicMap:   []string{"telegraf/{host}"},
+		Formats: map[string]*FormatConf{
+			"telegraf": {Topic: []string{"telegraf/#"}, Decoder: "influx"},
+		},
+	})
+	assert.Nil(err)
+
+	points := coder.Encode(Message{
+		Topic:   "telegraf/b",
+		Payload: []byte("cpu,cpu=0 usage=0.5 1600000000000000000\ncpu,cpu=1 usage=0.7 1600000000000000000"),
+	})
+	assert.Equal(2, len(points))
+	assert.Equal("cpu", points[0].Name())
+	assert.Equal(time.Unix(1600000000, 0), points[0].Time())
+	assert.Equal([]*lp.Tag{
+		{Key: "cpu", Value: "1"},
+		{Key: "host", Value: "b"},
+		{Key: "site", Value: "tokyo"},
+	}, points[1].TagList())
+
+	_, err = NewMqttSeriesEncoder(&InfluxDBConf{StaticTag: []string{"site"}})
+	assert.NotNil(err)
+}
+
+func Test_EncodeLineProtocolTime(t *testing.T) {
+	assert := assert.New(t)
+
+	// a time field does not override the timestamp of the line unless
+	// timeField is set
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		NoTopicTag: true,
+		TimeFormat: TimeUnix,
+		Formats: map[string]*FormatConf{
+			"telegraf": {Topic: []string{"telegraf/#"}, Decoder: "influx"},
+		},
+	})
+	assert.Nil(err)
+
+	points := coder.Encode(Message{
+		Topic:   "telegraf/b",
+		Payload: []byte("job time=1700000000 1600000000000000000"),
+	})
+	assert.Equal(1, len(points))
+	assert.Equal(time.Unix(1600000000, 0), points[0].Time())
+	assert.Equal([]*lp.Field{{Key: "_time", Value: float64(1700000000)}}, points[0].FieldList())
+}
@@ -126,3 +126,43 @@ func (m *TopicMatcher) Match(topic string) (bool, map[string]string) {
 
 	return true, result
 }
+
+// MatchTopicFilter reports whether topic matches the MQTT topic filter,
+// which may contain the "+" and "#" wildcards.
+func MatchTopicFilter(filter, topic string) bool {
+	f := strings.Split(filter, MqttSeparator)
+	t := strings.Split(topic, MqttSeparator)
+
+	// topics starting with "$" are not matched by a leading wildcard
+	if strings.HasPrefix(topic, "$") && (f[0] == "+" || f[0] == "#") {
+		return false
+	}
+	for i, part := range f {
+		if part == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if part != "+" && part != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// TopicFilterPrefix returns the part of the filter before the first wildcard,
+// for example "site/" for "site/+/telemetry". A filter without wildcards has
+// no prefix.
+func TopicFilterPrefix(filter string) string {
+	v := strings.Split(filter, MqttSeparator)
+	for i, part := range v {
+		if part == "+" || part == "#" {
+			if i == 0 {
+				return ""
+			}
+			return strings.Join(v[:i], MqttSeparator) + MqttSeparator
+		}
+	}
+	return ""
+}
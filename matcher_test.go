@@ -17,3 +17,26 @@ func Test_RegexMatcher(t *testing.T) {
 		"sensor":   "temperature",
 	}, v)
 }
+
+func Test_MatchTopicFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(MatchTopicFilter("site/+/telemetry", "site/a/telemetry"))
+	assert.False(MatchTopicFilter("site/+/telemetry", "site/a/b/telemetry"))
+	assert.True(MatchTopicFilter("legacy/#", "legacy/a/b"))
+	assert.True(MatchTopicFilter("legacy/#", "legacy"))
+	assert.False(MatchTopicFilter("legacy/#", "other/a"))
+	assert.True(MatchTopicFilter("a/b", "a/b"))
+	assert.False(MatchTopicFilter("a/b", "a/b/c"))
+	assert.False(MatchTopicFilter("#", "$SYS/broker/uptime"))
+	assert.True(MatchTopicFilter("$SYS/#", "$SYS/broker/uptime"))
+}
+
+func Test_TopicFilterPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("site/", TopicFilterPrefix("site/+/telemetry"))
+	assert.Equal("mqforward/", TopicFilterPrefix("mqforward/#"))
+	assert.Equal("", TopicFilterPrefix("+/status"))
+	assert.Equal("", TopicFilterPrefix("a/b"))
+}
@@ -1,51 +1,79 @@
 package main
 
 import (
-	"encoding/json"
-	msgpack "github.com/vmihailenco/msgpack"
-	"strconv"
-	"strings"
+	"fmt"
+	"time"
 )
 
 type Message struct {
-	Topic   string
-	Payload []byte
-	Values []string
-	Keys   []float64
+	Topic         string // rewritten topic
+	OriginalTopic string // topic as published
+	Payload       []byte
+	Values        []string
+	Keys          []float64
+	Retained      bool
+
+	// MQTT v5 properties
+	ContentType    string
+	ResponseTopic  string
+	UserProperties map[string]string
+
+	Ack func() // set if the message must be acknowledged after it is written
+}
+
+// Record is a set of fields decoded from a payload which share a timestamp.
+type Record struct {
+	Measurement string    // empty if the payload has no measurement name
+	Time        time.Time // zero if the payload has no timestamp
+	Tags        map[string]string
+	Fields      map[string]interface{}
 }
 
+// PublishedTopic returns the topic as published, before it was rewritten.
+func (m Message) PublishedTopic() string {
+	if m.OriginalTopic != "" {
+		return m.OriginalTopic
+	}
+	return m.Topic
+}
+
+// ack acknowledges the message to the broker if required.
+func (m Message) ack() {
+	if m.Ack != nil {
+		m.Ack()
+	}
+}
+
+// MsgParse decodes the payload with an auto-detected decoder.
 func MsgParse(payload []byte) (map[string]interface{}, error) {
-	var j map[string]interface{}
+	return MsgParseContentType("", payload)
+}
 
-	// first, try msgpack
-	err := msgpack.Unmarshal(payload, &j)
+// MsgParseContentType parses the payload with the decoder selected by the
+// MQTT v5 content type. An empty or unknown content type falls back to
+// auto-detection. It uses the decoders of the default configuration.
+func MsgParseContentType(contentType string, payload []byte) (map[string]interface{}, error) {
+	decoders, err := NewDecoderRegistry(&InfluxDBConf{})
 	if err != nil {
-		// next, try json
-		err := json.Unmarshal(payload, &j)
-		if err != nil {
-			// try plain numbers
-			s := string(payload)
-			if strings.Contains(s, ".") {
-				value, err := strconv.ParseFloat(s, 64)
-				if err == nil {
-					j = map[string]interface{}{"value": value}
-				} else {
-					return j, err
-				}
-			} else {
-				value, err := strconv.ParseInt(s, 10, 64)
-				if err == nil {
-					j = map[string]interface{}{"value": value}
-				}else {
-					return j, err
-				}
-			}
-		}
+		return nil, err
 	}
+	records, _, err := decoders.Decode(Message{
+		Payload:     payload,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no fields in payload")
+	}
+	return renameTime(records[0].Fields), nil
+}
 
+func renameTime(j map[string]interface{}) map[string]interface{} {
 	if _, ok := j["time"]; ok {
 		j["_time"] = j["time"]
 		delete(j, "time")
 	}
-	return j, nil
+	return j
 }
@@ -17,3 +17,22 @@ func Test_MsgParse(t *testing.T) {
 	assert.True(2 == j["x"].(float64))
 	assert.True(4 == j["y"].(float64))
 }
+
+func Test_MsgParseContentType(t *testing.T) {
+	assert := assert.New(t)
+
+	j, err := MsgParseContentType("application/json; charset=utf-8", []byte(`{"x": 12}`))
+	assert.Nil(err)
+	assert.Equal(float64(12), j["x"])
+
+	j, err = MsgParseContentType("text/plain", []byte(`1.5`))
+	assert.Nil(err)
+	assert.Equal(float64(1.5), j["value"])
+
+	_, err = MsgParseContentType("application/json", []byte(`1.5`))
+	assert.NotNil(err)
+
+	j, err = MsgParseContentType("application/octet-stream", []byte(`{"x": 1}`))
+	assert.Nil(err)
+	assert.Equal(float64(1), j["x"])
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -11,14 +13,28 @@ const (
 	StatusStarted = "started"
 
 	MaxBufferSize = 4 // bufferd size to send influxDB
+
+	DefaultStatusInterval = 60 // seconds
 )
 
 type Forwarder struct {
-	mqclient *MqttClient
+	mqclient MqttSubscriber
 	ifclient *InfluxDBClient
 
 	mqttChan chan Message
 	ifChan   chan Message
+
+	mqttconf MqttConf
+	started  time.Time
+}
+
+// Status is the JSON status message published to MqttConf.StatusTopic.
+type Status struct {
+	Status   string  `json:"status"`
+	Uptime   float64 `json:"uptime"` // seconds
+	Broker   string  `json:"broker"`
+	InfluxDB bool    `json:"influxdb"` // InfluxDB responds to ping
+	StatsSnapshot
 }
 
 func NewForwarder(mqttconf MqttConf, ifconf InfluxDBConf) (*Forwarder, error) {
@@ -27,7 +43,7 @@ func NewForwarder(mqttconf MqttConf, ifconf InfluxDBConf) (*Forwarder, error) {
 	// channel  to InfluxDB
 	ifChan := make(chan Message, MaxBufferSize)
 
-	mqclient, err := NewMqttClient(mqttconf, mqttChan)
+	mqclient, err := NewMqttSubscriber(mqttconf, mqttChan)
 	if err != nil {
 		return nil, fmt.Errorf("mqtt init err: %s", err)
 	}
@@ -37,12 +53,58 @@ func NewForwarder(mqttconf MqttConf, ifconf InfluxDBConf) (*Forwarder, error) {
 	}
 	go ifclient.Start()
 
-	return &Forwarder{
+	f := &Forwarder{
 		mqclient: mqclient,
 		ifclient: ifclient,
 		mqttChan: mqttChan,
 		ifChan:   ifChan,
-	}, nil
+		mqttconf: mqttconf,
+		started:  time.Now(),
+	}
+	if mqttconf.StatusTopic != "" {
+		go f.publishStatus()
+	}
+	return f, nil
+}
+
+// Status returns the current status of the forwarder.
+func (f *Forwarder) Status() Status {
+	return Status{
+		Status:        StatusStarted,
+		Uptime:        time.Since(f.started).Seconds(),
+		Broker:        f.mqclient.ActiveBroker(),
+		InfluxDB:      f.ifclient.Ping(),
+		StatsSnapshot: stats.Snapshot(),
+	}
+}
+
+// publishStatus publishes the status to the status topic periodically.
+func (f *Forwarder) publishStatus() {
+	interval := f.mqttconf.StatusInterval
+	if interval <= 0 {
+		interval = DefaultStatusInterval
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if f.mqclient.State().State != StateConnected {
+			continue
+		}
+		payload, err := json.Marshal(f.Status())
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if err := f.mqclient.Publish(f.mqttconf.StatusTopic, 0, false, payload); err != nil {
+			log.Errorf("status publish failed: %s", err)
+		}
+	}
+}
+
+// MqttState returns the state of the connection to the MQTT broker.
+func (f *Forwarder) MqttState() ConnectionStatus {
+	return f.mqclient.State()
 }
 
 func (f *Forwarder) Start() error {
@@ -56,6 +118,4 @@ func (f *Forwarder) Start() error {
 			f.ifChan <- msg
 		}
 	}
-
-	return fmt.Errorf("quit start loop")
 }
@@ -6,8 +6,11 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -15,18 +18,86 @@ import (
 
 const (
 	MaxClientIdLen = 10
+	SharePrefix    = "$share/"
+
+	DefaultWillPayload  = "offline"
+	DefaultBirthPayload = "online"
+	PublishTimeout      = 10 * time.Second
+
+	RetainedAccept = "accept" // write every retained message
+	RetainedIgnore = "ignore" // skip retained messages
+	RetainedOnce   = "once"   // write the first retained message of each topic
 )
 
 type MqttConf struct {
 	Hostname   string
 	Port       int
+	Broker     []string // broker URLs in failover order, overrides Hostname and Port
 	Username   string
 	Password   string
 	Cafilepath string
 	ClientCert string
 	PrivateKey string
 	Topic      string
+	Qos        int    // QoS of Topic
+	ShareGroup string // subscribe as $share/<ShareGroup>/<filter>
 	Debug      string
+
+	ClientId          string // fixed client ID, random if empty
+	PersistentSession bool   // do not clean the session on connect, requires ClientId
+	SessionExpiry     int    // MQTT v5 session expiry in seconds for persistent sessions
+	AckAfterWrite     bool   // acknowledge messages after they are written to InfluxDB
+
+	WillTopic      string // last will, published by the broker when mqforward is gone
+	WillPayload    string
+	WillQos        int
+	WillRetain     bool
+	BirthTopic     string // retained message published on every connection
+	BirthPayload   string
+	BirthQos       int
+	StatusTopic    string // periodic JSON status
+	StatusInterval int    // seconds between status messages
+
+	Rewrite  []string // topic rewrite rules applied after those of the subscription
+	Exclude  []string // topic filters of messages to skip
+	Retained string   // retained message policy: accept, ignore or once
+
+	ConnectRetries       int // retries of the first connection, -1 retries forever
+	ConnectRetryInterval int // first wait in seconds between retries, doubled on each retry
+	MaxReconnectInterval int // maximum wait in seconds between retries and reconnects
+
+	ProtocolVersion int // 5 selects MQTT v5, otherwise MQTT 3.1.1 is used
+
+	Subscriptions map[string]*SubscriptionConf // filled from [mqforward-subscription "name"] sections
+}
+
+// SubscriptionConf is one topic filter to subscribe to.
+type SubscriptionConf struct {
+	Topic      string
+	Qos        int
+	Strip      string   // prefix removed from received topics, defaults to the part before the first wildcard
+	NoStrip    bool     // keep received topics as they are
+	ShareGroup string   // overrides MqttConf.ShareGroup
+	Rewrite    []string // topic rewrite rules applied after Strip
+}
+
+// Subscription is a topic filter subscribed on the broker.
+type Subscription struct {
+	Filter string // topic filter without the $share part
+	Qos    byte
+	Strip  string
+	Group  string // shared subscription group
+
+	Rewrite []TopicRewriter // applied after Strip
+}
+
+// SubscribeTopic returns the filter to subscribe to, which is the shared
+// subscription form if Group is set.
+func (s Subscription) SubscribeTopic() string {
+	if s.Group == "" {
+		return s.Filter
+	}
+	return SharePrefix + s.Group + MqttSeparator + s.Filter
 }
 
 type MqttClient struct {
@@ -35,25 +106,135 @@ type MqttClient struct {
 	Config     MqttConf
 	Subscribed map[string]byte
 
-	mqttChan chan Message // chan to forwarder
-	lock     *sync.Mutex  // use for reconnect
+	recv  *receiver
+	lock  *sync.Mutex // use for reconnect
+	state *connTracker
+}
+
+// MqttSubscriber is a connection to the MQTT broker which sends the received
+// messages to the forwarder.
+type MqttSubscriber interface {
+	ActiveBroker() string
+	State() ConnectionStatus
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Disconnect() error
+}
+
+// NewMqttSubscriber connects to the MQTT broker with the protocol version
+// selected in conf.
+func NewMqttSubscriber(conf MqttConf, mqttChan chan Message) (MqttSubscriber, error) {
+	if conf.ProtocolVersion == 5 {
+		return NewMqttV5Client(conf, mqttChan)
+	}
+	return NewMqttClient(conf, mqttChan)
+}
+
+// receiver turns messages received by either MQTT client into Messages for
+// the forwarder.
+type receiver struct {
+	subscriptions []Subscription
+	exclude       []string
+	retained      string
+	mqttChan      chan Message // chan to forwarder
+
+	lock         sync.Mutex
+	seenRetained map[string]bool // topics of retained messages already written
+}
+
+func newReceiver(conf MqttConf, mqttChan chan Message) (*receiver, error) {
+	subscriptions, err := createSubscriptions(conf)
+	if err != nil {
+		return nil, err
+	}
+	retained := conf.Retained
+	switch retained {
+	case "":
+		retained = RetainedAccept
+	case RetainedAccept, RetainedIgnore, RetainedOnce:
+	default:
+		return nil, fmt.Errorf("invalid retained policy %q", conf.Retained)
+	}
+	return &receiver{
+		subscriptions: subscriptions,
+		exclude:       conf.Exclude,
+		retained:      retained,
+		mqttChan:      mqttChan,
+		seenRetained:  map[string]bool{},
+	}, nil
+}
+
+// subscribed returns the topic filters with their QoS.
+func (r *receiver) subscribed() map[string]byte {
+	ret := map[string]byte{}
+	for _, s := range r.subscriptions {
+		ret[s.SubscribeTopic()] = s.Qos
+	}
+	return ret
+}
+
+func (r *receiver) receive(msg Message) {
+	log.Debugf("topic:%s", msg.Topic)
+	stats.received.Add(1)
+
+	if r.skip(msg) {
+		stats.dropped.Add(1)
+		msg.ack()
+		return
+	}
+
+	// Remove topic root and rewrite by the first matching subscription
+	msg.OriginalTopic = msg.Topic
+	for _, s := range r.subscriptions {
+		if MatchTopicFilter(s.Filter, msg.Topic) {
+			topic := strings.TrimPrefix(msg.Topic, s.Strip)
+			msg.Topic = RewriteTopic(s.Rewrite, topic)
+			break
+		}
+	}
+
+	r.mqttChan <- msg
+}
+
+// skip reports whether the message is excluded by topic or by the retained
+// message policy.
+func (r *receiver) skip(msg Message) bool {
+	for _, filter := range r.exclude {
+		if MatchTopicFilter(filter, msg.Topic) {
+			log.Debugf("excluded by %s: %s", filter, msg.Topic)
+			return true
+		}
+	}
+	if !msg.Retained {
+		return false
+	}
+	switch r.retained {
+	case RetainedIgnore:
+		log.Debugf("retained message ignored: %s", msg.Topic)
+		return true
+	case RetainedOnce:
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		if r.seenRetained[msg.Topic] {
+			log.Debugf("retained message already written: %s", msg.Topic)
+			return true
+		}
+		r.seenRetained[msg.Topic] = true
+	}
+	return false
 }
 
 // with Connects connect to the MQTT broker with Options.
 func NewMqttClient(conf MqttConf, mqttChan chan Message) (*MqttClient, error) {
 	opts := MQTT.NewClientOptions()
 
-	port := conf.Port
-	if port == 0 {
-		port = 1883
+	brokers, err := getBrokerUrls(conf)
+	if err != nil {
+		return nil, err
 	}
-	scheme := "tcp"
-	if port == 8883 {
-		scheme = "ssl"
+	for _, broker := range brokers {
+		log.Infof("Broker URI: %s", broker)
+		opts.AddBroker(broker.String())
 	}
-	brokerUri := fmt.Sprintf("%s://%s:%d", scheme, conf.Hostname, port)
-	log.Infof("Broker URI: %s", brokerUri)
-	opts.AddBroker(brokerUri)
 
 	if conf.Username != "" {
 		opts.SetUsername(conf.Username)
@@ -62,17 +243,32 @@ func NewMqttClient(conf MqttConf, mqttChan chan Message) (*MqttClient, error) {
 		opts.SetPassword(conf.Password)
 	}
 
-	clientId := getRandomClientId()
+	clientId, err := getClientId(conf)
+	if err != nil {
+		return nil, err
+	}
 	opts.SetClientID(clientId)
+	opts.SetCleanSession(!conf.PersistentSession)
+	opts.SetAutoAckDisabled(conf.AckAfterWrite)
 	opts.SetAutoReconnect(true)
+	_, maxInterval := retryIntervals(conf)
+	opts.SetMaxReconnectInterval(maxInterval)
 
-	topic := conf.Topic
-	if strings.HasSuffix(topic, "#") == false {
-		topic = topic + "#"
+	if conf.WillTopic != "" {
+		if conf.WillQos < 0 || conf.WillQos > 2 {
+			return nil, fmt.Errorf("invalid willQos %d", conf.WillQos)
+		}
+		opts.SetWill(conf.WillTopic, willPayload(conf), byte(conf.WillQos), willRetain(conf))
+	}
+	if conf.BirthQos < 0 || conf.BirthQos > 2 {
+		return nil, fmt.Errorf("invalid birthQos %d", conf.BirthQos)
 	}
-	subscribed := map[string]byte{
-		topic: byte(0),
+
+	recv, err := newReceiver(conf, mqttChan)
+	if err != nil {
+		return nil, err
 	}
+	subscribed := recv.subscribed()
 
 	tlsConfig, ok, err := makeTlsConfig(conf.Cafilepath, conf.ClientCert, conf.PrivateKey, false)
 	if err != nil {
@@ -85,12 +281,11 @@ func NewMqttClient(conf MqttConf, mqttChan chan Message) (*MqttClient, error) {
 	ret := &MqttClient{
 		Config:     conf,
 		Subscribed: subscribed,
+		recv:       recv,
 		lock:       new(sync.Mutex),
-		mqttChan:   mqttChan,
+		state:      newConnTracker(),
 	}
-	opts.SetOnConnectHandler(ret.SubscribeOnConnect)
-	opts.SetConnectionLostHandler(ret.ConnectionLost)
-
+	ret.setHandlers(opts)
 	ret.Opts = opts
 
 	client, err := ret.Connect(conf, opts, subscribed)
@@ -102,16 +297,195 @@ func NewMqttClient(conf MqttConf, mqttChan chan Message) (*MqttClient, error) {
 	return ret, nil
 }
 
-// connects MQTT broker
+func willPayload(conf MqttConf) string {
+	if conf.WillPayload == "" {
+		return DefaultWillPayload
+	}
+	return conf.WillPayload
+}
+
+// willRetain reports whether the will is retained. It always is when it
+// shares the topic with the birth message, which would outlive mqforward
+// otherwise.
+func willRetain(conf MqttConf) bool {
+	return conf.WillRetain || (conf.WillTopic != "" && conf.WillTopic == conf.BirthTopic)
+}
+
+func birthPayload(conf MqttConf) string {
+	if conf.BirthPayload == "" {
+		return DefaultBirthPayload
+	}
+	return conf.BirthPayload
+}
+
+// getBrokerUrls returns the configured broker URLs in failover order. If no
+// broker is configured, the URL is made from Hostname and Port.
+func getBrokerUrls(conf MqttConf) ([]*url.URL, error) {
+	brokers := conf.Broker
+	if len(brokers) == 0 {
+		brokers = []string{getBrokerUri(conf)}
+	}
+
+	ret := []*url.URL{}
+	for _, broker := range brokers {
+		u, err := url.Parse(broker)
+		if err != nil {
+			return nil, fmt.Errorf("invalid broker %s: %s", broker, err)
+		}
+		switch u.Scheme {
+		case "tcp", "ssl", "ws", "wss", "mqtt", "mqtts", "tls":
+		default:
+			return nil, fmt.Errorf("invalid broker %s: unsupported scheme %q", broker, u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid broker %s: no host", broker)
+		}
+		ret = append(ret, u)
+	}
+	return ret, nil
+}
+
+// getBrokerUri returns the broker URI. The scheme is ssl if port is 8883.
+func getBrokerUri(conf MqttConf) string {
+	port := conf.Port
+	if port == 0 {
+		port = 1883
+	}
+	scheme := "tcp"
+	if port == 8883 {
+		scheme = "ssl"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, conf.Hostname, port)
+}
+
+// createSubscriptions builds the subscription list from the configured
+// subscription sections, or from Topic if there are none.
+func createSubscriptions(conf MqttConf) ([]Subscription, error) {
+	rewrite, err := createTopicRewriters(conf.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conf.Subscriptions) == 0 {
+		group, topic, err := splitSharedFilter(conf.Topic, conf.ShareGroup)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(topic, "#") == false {
+			topic = topic + "#"
+		}
+		if conf.Qos < 0 || conf.Qos > 2 {
+			return nil, fmt.Errorf("invalid qos %d", conf.Qos)
+		}
+		return []Subscription{
+			{
+				Filter: topic,
+				Qos:    byte(conf.Qos),
+				Strip:  strings.TrimRight(topic, "#"),
+				Group:  group,
+
+				Rewrite: rewrite,
+			},
+		}, nil
+	}
+
+	// sections are matched in name order
+	names := make([]string, 0, len(conf.Subscriptions))
+	for name := range conf.Subscriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := []Subscription{}
+	for _, name := range names {
+		sc := conf.Subscriptions[name]
+		shareGroup := sc.ShareGroup
+		if shareGroup == "" {
+			shareGroup = conf.ShareGroup
+		}
+		group, topic, err := splitSharedFilter(sc.Topic, shareGroup)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %s: %s", name, err)
+		}
+		if topic == "" {
+			return nil, fmt.Errorf("subscription %s: topic is empty", name)
+		}
+		if sc.Qos < 0 || sc.Qos > 2 {
+			return nil, fmt.Errorf("subscription %s: invalid qos %d", name, sc.Qos)
+		}
+		strip := sc.Strip
+		if sc.NoStrip {
+			strip = ""
+		} else if strip == "" {
+			strip = TopicFilterPrefix(topic)
+		}
+		subRewrite, err := createTopicRewriters(sc.Rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("subscription %s: %s", name, err)
+		}
+		ret = append(ret, Subscription{
+			Filter: topic,
+			Qos:    byte(sc.Qos),
+			Strip:  strip,
+			Group:  group,
+
+			Rewrite: append(subRewrite, rewrite...),
+		})
+	}
+	return ret, nil
+}
+
+// splitSharedFilter splits a "$share/<group>/<filter>" topic into the group
+// and the real filter. A topic without the $share part is shared in group if
+// it is not empty.
+func splitSharedFilter(topic, group string) (string, string, error) {
+	if strings.HasPrefix(topic, SharePrefix) {
+		v := strings.SplitN(strings.TrimPrefix(topic, SharePrefix), MqttSeparator, 2)
+		if len(v) != 2 || v[0] == "" {
+			return "", "", fmt.Errorf("invalid shared subscription %s", topic)
+		}
+		group, topic = v[0], v[1]
+	}
+	if strings.ContainsAny(group, "/+#") {
+		return "", "", fmt.Errorf("invalid share group %q", group)
+	}
+	return group, topic, nil
+}
+
+// connects MQTT broker, retrying with exponential backoff up to
+// conf.ConnectRetries times.
 func (m MqttClient) Connect(conf MqttConf, opts *MQTT.ClientOptions, subscribed map[string]byte) (MQTT.Client, error) {
 	m.Client = MQTT.NewClient(m.Opts)
+	initial, max := retryIntervals(conf)
 
-	log.Info("connecting...")
+	for attempt := 0; ; attempt++ {
+		log.Info("connecting...")
 
-	if token := m.Client.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+		token := m.Client.Connect()
+		if token.Wait() && token.Error() == nil {
+			return m.Client, nil
+		}
+		err := token.Error()
+		m.state.failed(err)
+		if conf.ConnectRetries >= 0 && attempt >= conf.ConnectRetries {
+			return nil, err
+		}
+		wait := backoff(attempt, initial, max)
+		log.Errorf("connect failed, retry in %s: %s", wait, err)
+		time.Sleep(wait)
 	}
-	return m.Client, nil
+}
+
+// getClientId returns the configured ClientId or a random one. A persistent
+// session is bound to the client ID, so it must be configured.
+func getClientId(conf MqttConf) (string, error) {
+	if conf.ClientId != "" {
+		return conf.ClientId, nil
+	}
+	if conf.PersistentSession {
+		return "", fmt.Errorf("persistentSession requires clientId")
+	}
+	return getRandomClientId(), nil
 }
 
 // getRandomClientId returns randomized ClientId.
@@ -125,8 +499,40 @@ func getRandomClientId() string {
 	return "mqttforward-" + string(bytes)
 }
 
+// setHandlers sets the handlers of the client. With a persistent session, the
+// broker sends the queued messages before SubscribeOnConnect subscribes
+// again, and they reach the default handler.
+func (m *MqttClient) setHandlers(opts *MQTT.ClientOptions) {
+	opts.SetConnectionAttemptHandler(m.onConnectionAttempt)
+	opts.SetOnConnectHandler(m.SubscribeOnConnect)
+	opts.SetConnectionLostHandler(m.ConnectionLost)
+	opts.SetReconnectingHandler(m.onReconnecting)
+	opts.SetDefaultPublishHandler(m.onMessageReceived)
+}
+
+// ActiveBroker returns the broker which is or was connected last.
+func (m *MqttClient) ActiveBroker() string {
+	return m.state.Status().Broker
+}
+
+// State returns the state of the connection to the broker.
+func (m *MqttClient) State() ConnectionStatus {
+	return m.state.Status()
+}
+
+func (m *MqttClient) onConnectionAttempt(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+	m.state.attempt(broker.String())
+	log.Debugf("connecting to %s", broker)
+	return tlsCfg
+}
+
+func (m *MqttClient) onReconnecting(client MQTT.Client, opts *MQTT.ClientOptions) {
+	log.Info("reconnecting...")
+}
+
 func (m *MqttClient) SubscribeOnConnect(client MQTT.Client) {
-	log.Infof("mqtt connected")
+	m.state.connected()
+	log.Infof("mqtt connected to %s", m.ActiveBroker())
 	log.Infof("subscribed: %v", m.Subscribed)
 
 	if len(m.Subscribed) > 0 {
@@ -136,32 +542,54 @@ func (m *MqttClient) SubscribeOnConnect(client MQTT.Client) {
 			log.Error(token.Error())
 		}
 	}
+
+	if m.Config.BirthTopic != "" {
+		token := client.Publish(m.Config.BirthTopic, byte(m.Config.BirthQos), true, birthPayload(m.Config))
+		token.Wait()
+		if token.Error() != nil {
+			log.Error(token.Error())
+		}
+	}
+}
+
+func (m *MqttClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	token := m.Client.Publish(topic, qos, retained, payload)
+	if !token.WaitTimeout(PublishTimeout) {
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+	return token.Error()
 }
 func (m *MqttClient) ConnectionLost(client MQTT.Client, reason error) {
+	m.state.lost(reason)
 	log.Errorf("client disconnected: %s", reason)
 }
 
 func (m *MqttClient) Disconnect() error {
 	if m.Client.IsConnected() {
+		// the broker drops the will on a clean disconnect
+		if m.Config.WillTopic != "" {
+			err := m.Publish(m.Config.WillTopic, byte(m.Config.WillQos), willRetain(m.Config), []byte(willPayload(m.Config)))
+			if err != nil {
+				log.Error(err)
+			}
+		}
 		m.Client.Disconnect(20)
 		log.Info("client disconnected")
 	}
+	m.state.down()
 	return nil
 }
 
 func (m *MqttClient) onMessageReceived(client MQTT.Client, message MQTT.Message) {
-	log.Debugf("topic:%s", message.Topic())
-
-	// Remove topic root
-	ct := strings.TrimRight(m.Config.Topic, "#")
-	topic := strings.Replace(message.Topic(), ct, "", 1)
-
-	chun := Message{
-		Topic:   topic,
-		Payload: message.Payload(),
+	msg := Message{
+		Topic:    message.Topic(),
+		Payload:  message.Payload(),
+		Retained: message.Retained(),
 	}
-
-	m.mqttChan <- chun
+	if m.Config.AckAfterWrite {
+		msg.Ack = message.Ack
+	}
+	m.recv.receive(msg)
 }
 
 func getCertPool(pemPath string) (*x509.CertPool, error) {
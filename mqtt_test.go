@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMessage struct {
+	topic   string
+	payload []byte
+	acked   bool
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 1 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 1 }
+func (m *testMessage) Payload() []byte   { return m.payload }
+func (m *testMessage) Ack()              { m.acked = true }
+
+func Test_DefaultPublishHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	mqttChan := make(chan Message, 1)
+	conf := MqttConf{Topic: "sensors/#", Qos: 1, AckAfterWrite: true}
+	recv, err := newReceiver(conf, mqttChan)
+	assert.Nil(err)
+	m := &MqttClient{Config: conf, recv: recv, lock: new(sync.Mutex)}
+	opts := MQTT.NewClientOptions()
+	m.setHandlers(opts)
+
+	// messages queued in a persistent session arrive before the subscriptions
+	// are set and match no route, but are acknowledged after the write all the same
+	message := &testMessage{topic: "other/a", payload: []byte("1")}
+	opts.DefaultPublishHandler(nil, message)
+	msg := <-mqttChan
+	assert.Equal("other/a", msg.Topic)
+	assert.False(message.acked)
+	msg.ack()
+	assert.True(message.acked)
+}
+
+func Test_CreateSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	subs, err := createSubscriptions(MqttConf{
+		Subscriptions: map[string]*SubscriptionConf{
+			"telemetry": {Topic: "site/+/telemetry", Qos: 1},
+			"legacy":    {Topic: "legacy/#", Strip: "legacy/old/"},
+			"status":    {Topic: "gw/+/status", NoStrip: true},
+		},
+	})
+	assert.Nil(err)
+	assert.Equal([]Subscription{
+		{Filter: "legacy/#", Qos: 0, Strip: "legacy/old/"},
+		{Filter: "gw/+/status", Qos: 0, Strip: ""},
+		{Filter: "site/+/telemetry", Qos: 1, Strip: "site/"},
+	}, subs)
+
+	_, err = createSubscriptions(MqttConf{
+		Subscriptions: map[string]*SubscriptionConf{
+			"bad": {Topic: "a/#", Qos: 3},
+		},
+	})
+	assert.NotNil(err)
+}
+
+func Test_SharedSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	subs, err := createSubscriptions(MqttConf{
+		Topic:      "mqforward/#",
+		ShareGroup: "fwd",
+	})
+	assert.Nil(err)
+	assert.Equal("mqforward/#", subs[0].Filter)
+	assert.Equal("$share/fwd/mqforward/#", subs[0].SubscribeTopic())
+	assert.Equal("mqforward/", subs[0].Strip)
+
+	subs, err = createSubscriptions(MqttConf{
+		Subscriptions: map[string]*SubscriptionConf{
+			"a": {Topic: "$share/g1/site/+/telemetry"},
+			"b": {Topic: "legacy/#", ShareGroup: "g2"},
+		},
+	})
+	assert.Nil(err)
+	assert.Equal("site/+/telemetry", subs[0].Filter)
+	assert.Equal("site/", subs[0].Strip)
+	assert.Equal("$share/g1/site/+/telemetry", subs[0].SubscribeTopic())
+	assert.Equal("$share/g2/legacy/#", subs[1].SubscribeTopic())
+
+	_, err = createSubscriptions(MqttConf{Topic: "$share/onlygroup"})
+	assert.NotNil(err)
+}
+
+func Test_BrokerUrls(t *testing.T) {
+	assert := assert.New(t)
+
+	brokers, err := getBrokerUrls(MqttConf{Hostname: "localhost", Port: 8883})
+	assert.Nil(err)
+	assert.Equal("ssl://localhost:8883", brokers[0].String())
+
+	brokers, err = getBrokerUrls(MqttConf{
+		Hostname: "ignored",
+		Broker:   []string{"wss://mq.example.com:443/mqtt", "tcp://backup.example.com:1883"},
+	})
+	assert.Nil(err)
+	assert.Equal(2, len(brokers))
+	assert.Equal("wss", brokers[0].Scheme)
+	assert.Equal("/mqtt", brokers[0].Path)
+	assert.Equal("backup.example.com:1883", brokers[1].Host)
+
+	_, err = getBrokerUrls(MqttConf{Broker: []string{"http://localhost:1883"}})
+	assert.NotNil(err)
+}
+
+func Test_WillRetain(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(willRetain(MqttConf{WillTopic: "a/state"}))
+	assert.True(willRetain(MqttConf{WillTopic: "a/state", WillRetain: true}))
+	assert.True(willRetain(MqttConf{WillTopic: "a/state", BirthTopic: "a/state"}))
+	assert.False(willRetain(MqttConf{WillTopic: "a/will", BirthTopic: "a/state"}))
+}
+
+func Test_ReceiverSkip(t *testing.T) {
+	assert := assert.New(t)
+
+	mqttChan := make(chan Message, 10)
+	r, err := newReceiver(MqttConf{
+		Topic:    "site/#",
+		Exclude:  []string{"site/+/debug/#"},
+		Retained: RetainedOnce,
+	}, mqttChan)
+	assert.Nil(err)
+
+	r.receive(Message{Topic: "site/a/debug/x", Payload: []byte("1")})
+	r.receive(Message{Topic: "site/a/temp", Payload: []byte("1"), Retained: true})
+	r.receive(Message{Topic: "site/a/temp", Payload: []byte("2"), Retained: true})
+	r.receive(Message{Topic: "site/a/temp", Payload: []byte("3")})
+	assert.Equal(2, len(mqttChan))
+	assert.Equal("a/temp", (<-mqttChan).Topic)
+	assert.Equal([]byte("3"), (<-mqttChan).Payload)
+
+	r, err = newReceiver(MqttConf{Topic: "site/#", Retained: RetainedIgnore}, mqttChan)
+	assert.Nil(err)
+	r.receive(Message{Topic: "site/a/temp", Retained: true})
+	assert.Equal(0, len(mqttChan))
+
+	_, err = newReceiver(MqttConf{Topic: "site/#", Retained: "sometimes"}, mqttChan)
+	assert.NotNil(err)
+}
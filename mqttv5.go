@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+const (
+	KeepAlive            = 30               // seconds
+	ConnectTimeout       = 30 * time.Second // wait for disconnection
+	DefaultSessionExpiry = 24 * 60 * 60     // seconds
+)
+
+// MqttV5Client subscribes to the broker with MQTT v5.
+type MqttV5Client struct {
+	Manager    *autopaho.ConnectionManager
+	Config     MqttConf
+	Subscribed map[string]byte
+
+	recv  *receiver
+	state *connTracker
+}
+
+// NewMqttV5Client connects to the MQTT broker with MQTT v5. Reconnection is
+// handled by autopaho, which subscribes again on every connection.
+func NewMqttV5Client(conf MqttConf, mqttChan chan Message) (*MqttV5Client, error) {
+	brokers, err := getBrokerUrls(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, broker := range brokers {
+		log.Infof("Broker URI: %s (MQTT v5)", broker)
+	}
+
+	recv, err := newReceiver(conf, mqttChan)
+	if err != nil {
+		return nil, err
+	}
+	clientId, err := getClientId(conf)
+	if err != nil {
+		return nil, err
+	}
+	var sessionExpiry uint32
+	if conf.PersistentSession {
+		sessionExpiry = DefaultSessionExpiry
+		if conf.SessionExpiry > 0 {
+			sessionExpiry = uint32(conf.SessionExpiry)
+		}
+	}
+
+	ret := &MqttV5Client{
+		Config:     conf,
+		Subscribed: recv.subscribed(),
+		recv:       recv,
+		state:      newConnTracker(),
+	}
+
+	// startup is cancelled when the retries of the first connection are used up
+	startup, giveUp := context.WithCancel(context.Background())
+	defer giveUp()
+	initial, max := retryIntervals(conf)
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    brokers,
+		KeepAlive:                     KeepAlive,
+		CleanStartOnInitialConnection: !conf.PersistentSession,
+		SessionExpiryInterval:         sessionExpiry,
+		OnConnectionUp:                ret.SubscribeOnConnect,
+		OnConnectionDown:              ret.onConnectionDown,
+		ConnectPacketBuilder:          ret.onConnectionAttempt,
+		ReconnectBackoff: func(attempt int) time.Duration {
+			if attempt == 0 {
+				return 0
+			}
+			if ret.state.Status().LastConnected.IsZero() &&
+				conf.ConnectRetries >= 0 && attempt > conf.ConnectRetries {
+				giveUp()
+			}
+			wait := backoff(attempt-1, initial, max)
+			log.Infof("reconnecting in %s", wait)
+			return wait
+		},
+		OnConnectError: func(err error) {
+			ret.state.failed(err)
+			log.Errorf("connection error: %s", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:                   clientId,
+			EnableManualAcknowledgment: conf.AckAfterWrite,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				ret.onPublishReceived,
+			},
+			OnClientError: func(err error) {
+				log.Errorf("client error: %s", err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				log.Errorf("server disconnected: reason code %d", d.ReasonCode)
+			},
+		},
+	}
+	cfg.SetUsernamePassword(conf.Username, []byte(conf.Password))
+	if conf.WillTopic != "" {
+		if conf.WillQos < 0 || conf.WillQos > 2 {
+			return nil, fmt.Errorf("invalid willQos %d", conf.WillQos)
+		}
+		cfg.SetWillMessage(conf.WillTopic, []byte(willPayload(conf)), byte(conf.WillQos), willRetain(conf))
+	}
+	if conf.BirthQos < 0 || conf.BirthQos > 2 {
+		return nil, fmt.Errorf("invalid birthQos %d", conf.BirthQos)
+	}
+
+	tlsConfig, ok, err := makeTlsConfig(conf.Cafilepath, conf.ClientCert, conf.PrivateKey, false)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		cfg.TlsCfg = tlsConfig
+	}
+
+	log.Info("connecting...")
+	cm, err := autopaho.NewConnection(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cm.AwaitConnection(startup); err != nil {
+		cm.Disconnect(context.Background())
+		if lastErr := ret.state.Status().LastError; lastErr != nil {
+			return nil, lastErr
+		}
+		return nil, err
+	}
+	ret.Manager = cm
+
+	return ret, nil
+}
+
+// ActiveBroker returns the broker which is or was connected last.
+func (m *MqttV5Client) ActiveBroker() string {
+	return m.state.Status().Broker
+}
+
+// State returns the state of the connection to the broker.
+func (m *MqttV5Client) State() ConnectionStatus {
+	return m.state.Status()
+}
+
+func (m *MqttV5Client) onConnectionAttempt(cp *paho.Connect, broker *url.URL) (*paho.Connect, error) {
+	m.state.attempt(broker.String())
+	log.Debugf("connecting to %s", broker)
+	return cp, nil
+}
+
+func (m *MqttV5Client) onConnectionDown() bool {
+	m.state.lost(nil)
+	log.Errorf("client disconnected")
+	return true
+}
+
+func (m *MqttV5Client) SubscribeOnConnect(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+	m.state.connected()
+	log.Infof("mqtt connected to %s", m.ActiveBroker())
+	log.Infof("subscribed: %v", m.Subscribed)
+
+	sub := &paho.Subscribe{}
+	for topic, qos := range m.Subscribed {
+		sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{
+			Topic: topic,
+			QoS:   qos,
+		})
+	}
+	// OnConnectionUp must not block
+	go func() {
+		if len(sub.Subscriptions) > 0 {
+			if _, err := cm.Subscribe(context.Background(), sub); err != nil {
+				log.Error(err)
+			}
+		}
+		if m.Config.BirthTopic != "" {
+			_, err := cm.Publish(context.Background(), &paho.Publish{
+				Topic:   m.Config.BirthTopic,
+				QoS:     byte(m.Config.BirthQos),
+				Retain:  true,
+				Payload: []byte(birthPayload(m.Config)),
+			})
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}()
+}
+
+func (m *MqttV5Client) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), PublishTimeout)
+	defer cancel()
+	_, err := m.Manager.Publish(ctx, &paho.Publish{
+		Topic:   topic,
+		QoS:     qos,
+		Retain:  retained,
+		Payload: payload,
+	})
+	return err
+}
+
+func (m *MqttV5Client) Disconnect() error {
+	if m.Manager == nil {
+		return nil
+	}
+	// the broker drops the will on a clean disconnect
+	if m.Config.WillTopic != "" {
+		err := m.Publish(m.Config.WillTopic, byte(m.Config.WillQos), willRetain(m.Config), []byte(willPayload(m.Config)))
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ConnectTimeout)
+	defer cancel()
+	err := m.Manager.Disconnect(ctx)
+	m.state.down()
+	if err != nil {
+		return err
+	}
+	log.Info("client disconnected")
+	return nil
+}
+
+func (m *MqttV5Client) onPublishReceived(pr paho.PublishReceived) (bool, error) {
+	msg := Message{
+		Topic:    pr.Packet.Topic,
+		Payload:  pr.Packet.Payload,
+		Retained: pr.Packet.Retain,
+	}
+	if props := pr.Packet.Properties; props != nil {
+		msg.ContentType = props.ContentType
+		msg.ResponseTopic = props.ResponseTopic
+		if len(props.User) > 0 {
+			msg.UserProperties = map[string]string{}
+			for _, p := range props.User {
+				msg.UserProperties[p.Key] = p.Value
+			}
+		}
+	}
+	if m.Config.AckAfterWrite {
+		msg.Ack = func() {
+			if err := pr.Client.Ack(pr.Packet); err != nil {
+				log.Errorf("ack failed: %s", err)
+			}
+		}
+	}
+	m.recv.receive(msg)
+	return true, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const DefaultPlainField = "value"
+
+// PlainDecoder decodes a plain number, a bool or enum literal, or optionally
+// any text into a single field. Literals are matched case-insensitively.
+type PlainDecoder struct {
+	field   string
+	trues   map[string]bool
+	falses  map[string]bool
+	enum    map[string]int64
+	strings bool
+}
+
+func NewPlainDecoder(field string, f *FormatConf) (*PlainDecoder, error) {
+	d := &PlainDecoder{
+		field:   field,
+		trues:   literals(f.True, "true"),
+		falses:  literals(f.False, "false"),
+		enum:    map[string]int64{},
+		strings: f.Strings,
+	}
+	if f.Field != "" {
+		d.field = f.Field
+	}
+	if d.field == "" {
+		d.field = DefaultPlainField
+	}
+	for _, e := range f.Enum {
+		v := strings.SplitN(e, "=", 2)
+		if len(v) != 2 {
+			return nil, fmt.Errorf("plain: enum must be 'literal=number': %s", e)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(v[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plain: enum %s: %s", e, err)
+		}
+		d.enum[strings.ToLower(strings.TrimSpace(v[0]))] = n
+	}
+	return d, nil
+}
+
+// literals returns the lower case literals, or the default.
+func literals(conf []string, def string) map[string]bool {
+	if len(conf) == 0 {
+		conf = []string{def}
+	}
+	ret := map[string]bool{}
+	for _, l := range conf {
+		ret[strings.ToLower(strings.TrimSpace(l))] = true
+	}
+	return ret
+}
+
+func (d *PlainDecoder) ContentType() string {
+	return "text/plain"
+}
+
+func (d *PlainDecoder) Decode(msg Message) ([]Record, error) {
+	s := strings.TrimSpace(string(msg.Payload))
+	value, err := d.value(s)
+	if err != nil {
+		return nil, err
+	}
+	return fieldRecords(map[string]interface{}{d.field: value}), nil
+}
+
+func (d *PlainDecoder) value(s string) (interface{}, error) {
+	l := strings.ToLower(s)
+	if n, ok := d.enum[l]; ok {
+		return n, nil
+	}
+	if d.trues[l] {
+		return true, nil
+	}
+	if d.falses[l] {
+		return false, nil
+	}
+	v, err := parseNumber(s)
+	if err == nil {
+		return v, nil
+	}
+	if !d.strings || s == "" {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseNumber parses an integer, or a float if it has a decimal point.
+func parseNumber(s string) (interface{}, error) {
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PlainDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewPlainDecoder("", &FormatConf{})
+	assert.Nil(err)
+	cases := map[string]interface{}{
+		"12":     int64(12),
+		"1.5\n":  1.5,
+		"TRUE":   true,
+		" false": false,
+	}
+	for payload, expected := range cases {
+		records, err := d.Decode(Message{Payload: []byte(payload)})
+		assert.Nil(err, payload)
+		assert.Equal(map[string]interface{}{"value": expected}, records[0].Fields, payload)
+	}
+	_, err = d.Decode(Message{Payload: []byte("ON")})
+	assert.NotNil(err)
+
+	d, err = NewPlainDecoder("value", &FormatConf{
+		Field:   "state",
+		True:    []string{"on", "open"},
+		False:   []string{"off", "closed"},
+		Enum:    []string{"idle=0", "heating=1", "cooling = 2"},
+		Strings: true,
+	})
+	assert.Nil(err)
+	cases = map[string]interface{}{
+		"ON":      true,
+		"closed":  false,
+		"true":    "true",
+		"Cooling": int64(2),
+		"3":       int64(3),
+		"error 4": "error 4",
+	}
+	for payload, expected := range cases {
+		records, err := d.Decode(Message{Payload: []byte(payload)})
+		assert.Nil(err, payload)
+		assert.Equal(map[string]interface{}{"state": expected}, records[0].Fields, payload)
+	}
+	_, err = d.Decode(Message{Payload: []byte(" ")})
+	assert.NotNil(err)
+
+	_, err = NewPlainDecoder("", &FormatConf{Enum: []string{"idle"}})
+	assert.NotNil(err)
+	_, err = NewPlainDecoder("", &FormatConf{Enum: []string{"idle=low"}})
+	assert.NotNil(err)
+}
+
+func Test_EncodePlainField(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		PlainField: "reading",
+		Formats: map[string]*FormatConf{
+			"switches": {Topic: []string{"stat/+/POWER"}, Decoder: "plain", True: []string{"on"}, False: []string{"off"}},
+		},
+	})
+	assert.Nil(err)
+
+	points := coder.Encode(Message{Topic: "tele/a/temp", Payload: []byte("21.5")})
+	assert.Equal(1, len(points))
+	assert.Equal("reading", points[0].FieldList()[0].Key)
+
+	points = coder.Encode(Message{Topic: "stat/a/POWER", Payload: []byte("OFF")})
+	assert.Equal(1, len(points))
+	assert.Equal("reading", points[0].FieldList()[0].Key)
+	assert.Equal(false, points[0].FieldList()[0].Value)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	// registers the well-known types which descriptor sets may import
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	EnumsString = "string" // enum values are stored as their names
+	EnumsNumber = "number" // enum values are stored as their numbers
+
+	timestampName protoreflect.FullName = "google.protobuf.Timestamp"
+)
+
+// ProtobufDecoder decodes protobuf messages of a type from descriptor set
+// files, which are written by `protoc --include_imports --descriptor_set_out`.
+// Nested messages become nested fields, and a google.protobuf.Timestamp field
+// of the message sets the time of the record.
+type ProtobufDecoder struct {
+	message     protoreflect.MessageDescriptor
+	enumNumbers bool
+}
+
+func NewProtobufDecoder(f *FormatConf) (*ProtobufDecoder, error) {
+	if len(f.Descriptor) == 0 {
+		return nil, fmt.Errorf("protobuf: descriptor is empty")
+	}
+	if f.Message == "" {
+		return nil, fmt.Errorf("protobuf: message is empty")
+	}
+	d := &ProtobufDecoder{}
+	switch f.Enums {
+	case "", EnumsString:
+	case EnumsNumber:
+		d.enumNumbers = true
+	default:
+		return nil, fmt.Errorf("protobuf: unknown enums %q", f.Enums)
+	}
+
+	files := &protoregistry.Files{}
+	for _, path := range f.Descriptor {
+		if err := loadDescriptorSet(files, ExpandPath(path)); err != nil {
+			return nil, err
+		}
+	}
+	desc, err := (descriptorResolver{files}).FindDescriptorByName(protoreflect.FullName(f.Message))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf: message %s: %s", f.Message, err)
+	}
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("protobuf: %s is not a message", f.Message)
+	}
+	d.message = message
+	return d, nil
+}
+
+// loadDescriptorSet registers the files of a descriptor set.
+func loadDescriptorSet(files *protoregistry.Files, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, set); err != nil {
+		return fmt.Errorf("protobuf: %s: %s", path, err)
+	}
+	resolver := descriptorResolver{files}
+	for _, fd := range set.File {
+		if _, err := resolver.FindFileByPath(fd.GetName()); err == nil {
+			// well-known types or a file of another descriptor set
+			continue
+		}
+		file, err := protodesc.NewFile(fd, resolver)
+		if err != nil {
+			return fmt.Errorf("protobuf: %s: %s", path, err)
+		}
+		if err := files.RegisterFile(file); err != nil {
+			return fmt.Errorf("protobuf: %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// descriptorResolver finds descriptors in the loaded files and then in the
+// files linked into mqforward.
+type descriptorResolver struct {
+	files *protoregistry.Files
+}
+
+func (r descriptorResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r descriptorResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+func (d *ProtobufDecoder) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (d *ProtobufDecoder) Decode(msg Message) ([]Record, error) {
+	m := dynamicpb.NewMessage(d.message)
+	if err := proto.Unmarshal(msg.Payload, m); err != nil {
+		return nil, err
+	}
+
+	r := Record{Fields: map[string]interface{}{}}
+	rangeFields(m, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+		if r.Time.IsZero() && fd.Message() != nil && fd.Message().FullName() == timestampName &&
+			!fd.IsList() && !fd.IsMap() {
+			r.Time = protoTimestamp(v.Message())
+			return
+		}
+		r.Fields[string(fd.Name())] = d.fieldValue(fd, v)
+	})
+	return []Record{r}, nil
+}
+
+// rangeFields calls f for the fields of m in declaration order. Unlike
+// m.Range, scalars without presence are visited with their zero value, which
+// proto3 does not send. Unset message, oneof and optional fields and empty
+// lists and maps are skipped.
+func rangeFields(m protoreflect.Message, f func(protoreflect.FieldDescriptor, protoreflect.Value)) {
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !m.Has(fd) && (fd.HasPresence() || fd.IsList() || fd.IsMap()) {
+			continue
+		}
+		f(fd, m.Get(fd))
+	}
+}
+
+// fieldValue converts a field to a value, a nested map or a list.
+func (d *ProtobufDecoder) fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		ret := make([]interface{}, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			ret[i] = d.singularValue(fd, list.Get(i))
+		}
+		return ret
+	case fd.IsMap():
+		ret := map[string]interface{}{}
+		v.Map().Range(func(k protoreflect.MapKey, e protoreflect.Value) bool {
+			ret[k.String()] = d.singularValue(fd.MapValue(), e)
+			return true
+		})
+		return ret
+	}
+	return d.singularValue(fd, v)
+}
+
+func (d *ProtobufDecoder) singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		// not a valid field value
+		return nil
+	case protoreflect.EnumKind:
+		if !d.enumNumbers {
+			if e := fd.Enum().Values().ByNumber(v.Enum()); e != nil {
+				return string(e.Name())
+			}
+		}
+		return int64(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return d.messageValue(v.Message())
+	}
+	return nil
+}
+
+func (d *ProtobufDecoder) messageValue(m protoreflect.Message) interface{} {
+	desc := m.Descriptor()
+	if desc.FullName() == timestampName {
+		return protoTimestamp(m).Format(time.RFC3339Nano)
+	}
+	if desc.FullName().Parent() == "google.protobuf" && desc.Fields().Len() == 1 &&
+		desc.Fields().Get(0).Name() == "value" {
+		// wrappers such as google.protobuf.DoubleValue
+		fd := desc.Fields().Get(0)
+		return d.singularValue(fd, m.Get(fd))
+	}
+
+	ret := map[string]interface{}{}
+	rangeFields(m, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+		ret[string(fd.Name())] = d.fieldValue(fd, v)
+	})
+	return ret
+}
+
+func protoTimestamp(m protoreflect.Message) time.Time {
+	fields := m.Descriptor().Fields()
+	seconds := m.Get(fields.ByName("seconds")).Int()
+	nanos := m.Get(fields.ByName("nanos")).Int()
+	return time.Unix(seconds, nanos)
+}
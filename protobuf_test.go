@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// writeDescriptorSet writes the descriptor set of test.Reading.
+func writeDescriptorSet(t *testing.T) string {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	values := field("values", 6, descriptorpb.FieldDescriptorProto_TYPE_INT64, "")
+	values.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	battery := field("battery", 7, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "")
+	battery.Proto3Optional = proto.Bool(true)
+	battery.OneofIndex = proto.Int32(0)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("reading.proto"),
+		Package:    proto.String("test"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("OK"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Reading"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				field("device", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				field("temp", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				field("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status"),
+				field("time", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+				field("env", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Reading.Env"),
+				values,
+				battery,
+			},
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_battery")}},
+			NestedType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Env"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("hum", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				},
+			}},
+		}},
+	}
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+			file,
+		},
+	}
+	raw, err := proto.Marshal(set)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "reading.pb")
+	assert.Nil(t, ioutil.WriteFile(path, raw, 0600))
+	return path
+}
+
+func Test_ProtobufDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := &FormatConf{
+		Descriptor: []string{writeDescriptorSet(t)},
+		Message:    "test.Reading",
+	}
+	d, err := NewProtobufDecoder(conf)
+	assert.Nil(err)
+
+	fields := d.message.Fields()
+	m := dynamicpb.NewMessage(d.message)
+	m.Set(fields.ByName("device"), protoreflect.ValueOfString("a"))
+	m.Set(fields.ByName("temp"), protoreflect.ValueOfFloat64(21.5))
+	m.Set(fields.ByName("status"), protoreflect.ValueOfEnum(1))
+	ts := m.Mutable(fields.ByName("time")).Message()
+	ts.Set(ts.Descriptor().Fields().ByName("seconds"), protoreflect.ValueOfInt64(1600000000))
+	env := m.Mutable(fields.ByName("env")).Message()
+	env.Set(env.Descriptor().Fields().ByName("hum"), protoreflect.ValueOfInt32(40))
+	list := m.Mutable(fields.ByName("values")).List()
+	list.Append(protoreflect.ValueOfInt64(1))
+	list.Append(protoreflect.ValueOfInt64(2))
+	payload, err := proto.Marshal(m)
+	assert.Nil(err)
+
+	records, err := d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal(1, len(records))
+	assert.Equal(time.Unix(1600000000, 0), records[0].Time)
+	assert.Equal(map[string]interface{}{
+		"device": "a",
+		"temp":   21.5,
+		"status": "OK",
+		"env":    map[string]interface{}{"hum": int64(40)},
+		"values": []interface{}{int64(1), int64(2)},
+	}, records[0].Fields)
+
+	conf.Enums = "number"
+	d, err = NewProtobufDecoder(conf)
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal(int64(1), records[0].Fields["status"])
+
+	// zero values are not sent, unset optional fields are not written
+	fields = d.message.Fields()
+	m = dynamicpb.NewMessage(d.message)
+	m.Set(fields.ByName("device"), protoreflect.ValueOfString("a"))
+	payload, err = proto.Marshal(m)
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.True(records[0].Time.IsZero())
+	assert.Equal(map[string]interface{}{
+		"device": "a",
+		"temp":   0.0,
+		"status": int64(0),
+	}, records[0].Fields)
+
+	m.Set(fields.ByName("battery"), protoreflect.ValueOfFloat64(0))
+	payload, err = proto.Marshal(m)
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal(0.0, records[0].Fields["battery"])
+
+	_, err = d.Decode(Message{Payload: []byte{0xff}})
+	assert.NotNil(err)
+
+	conf.Message = "test.Missing"
+	_, err = NewProtobufDecoder(conf)
+	assert.NotNil(err)
+}
+
+func Test_EncodeProtobuf(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		Formats: map[string]*FormatConf{
+			"readings": {
+				Topic:      []string{"sensors/+/reading"},
+				Decoder:    "protobuf",
+				Descriptor: []string{writeDescriptorSet(t)},
+				Message:    "test.Reading",
+			},
+		},
+	})
+	assert.Nil(err)
+
+	d := coder.decoders.bindings[0].decoder.(*ProtobufDecoder)
+	m := dynamicpb.NewMessage(d.message)
+	env := m.Mutable(d.message.Fields().ByName("env")).Message()
+	env.Set(env.Descriptor().Fields().ByName("hum"), protoreflect.ValueOfInt32(40))
+	payload, err := proto.Marshal(m)
+	assert.Nil(err)
+
+	points := coder.Encode(Message{Topic: "sensors/a/reading", Payload: payload})
+	assert.Equal(1, len(points))
+	keys := []string{}
+	for _, f := range points[0].FieldList() {
+		keys = append(keys, f.Key)
+	}
+	assert.Equal([]string{"device", "env_hum", "status", "temp"}, keys)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TopicRewriter changes the topic of a received message before it is encoded.
+type TopicRewriter interface {
+	Rewrite(topic string) string
+}
+
+// StripRewriter removes a prefix.
+type StripRewriter struct {
+	prefix string
+}
+
+func (r *StripRewriter) Rewrite(topic string) string {
+	return strings.TrimPrefix(topic, r.prefix)
+}
+
+// RegexRewriter replaces matches of a regular expression. The replacement
+// may refer to submatches as $1.
+type RegexRewriter struct {
+	reg         *regexp.Regexp
+	replacement string
+}
+
+func (r *RegexRewriter) Rewrite(topic string) string {
+	return r.reg.ReplaceAllString(topic, r.replacement)
+}
+
+// LowerRewriter converts the topic to lower case.
+type LowerRewriter struct{}
+
+func (r *LowerRewriter) Rewrite(topic string) string {
+	return strings.ToLower(topic)
+}
+
+// SegmentsRewriter keeps the listed topic segments in the listed order.
+// Segments out of range are skipped.
+type SegmentsRewriter struct {
+	indexes []int
+}
+
+func (r *SegmentsRewriter) Rewrite(topic string) string {
+	v := strings.Split(topic, MqttSeparator)
+	ret := []string{}
+	for _, i := range r.indexes {
+		if i < len(v) {
+			ret = append(ret, v[i])
+		}
+	}
+	return strings.Join(ret, MqttSeparator)
+}
+
+// DropRewriter removes the listed topic segments.
+type DropRewriter struct {
+	indexes []int
+}
+
+func (r *DropRewriter) Rewrite(topic string) string {
+	v := strings.Split(topic, MqttSeparator)
+	ret := []string{}
+	for i, part := range v {
+		if !containsInt(r.indexes, i) {
+			ret = append(ret, part)
+		}
+	}
+	return strings.Join(ret, MqttSeparator)
+}
+
+func containsInt(v []int, n int) bool {
+	for _, i := range v {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTopicRewriter parses a rewrite rule:
+//
+//	strip <prefix>
+//	replace <regexp> [<replacement>]
+//	lower
+//	segments <i>,<j>,...  (keep segments in this order, counted from 0)
+//	drop <i>,<j>,...      (remove segments)
+func NewTopicRewriter(rule string) (TopicRewriter, error) {
+	v := strings.SplitN(strings.TrimSpace(rule), " ", 2)
+	arg := ""
+	if len(v) == 2 {
+		arg = strings.TrimSpace(v[1])
+	}
+
+	switch v[0] {
+	case "strip":
+		if arg == "" {
+			return nil, fmt.Errorf("rewrite %q: prefix is empty", rule)
+		}
+		return &StripRewriter{prefix: arg}, nil
+	case "replace":
+		a := strings.SplitN(arg, " ", 2)
+		reg, err := regexp.Compile(a[0])
+		if err != nil || a[0] == "" {
+			return nil, fmt.Errorf("rewrite %q: invalid regexp", rule)
+		}
+		r := &RegexRewriter{reg: reg}
+		if len(a) == 2 {
+			r.replacement = a[1]
+		}
+		return r, nil
+	case "lower":
+		return &LowerRewriter{}, nil
+	case "segments", "drop":
+		indexes := []int{}
+		for _, s := range strings.Split(arg, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("rewrite %q: invalid segment %q", rule, s)
+			}
+			indexes = append(indexes, i)
+		}
+		if v[0] == "drop" {
+			return &DropRewriter{indexes: indexes}, nil
+		}
+		return &SegmentsRewriter{indexes: indexes}, nil
+	}
+	return nil, fmt.Errorf("rewrite %q: unknown rule", rule)
+}
+
+func createTopicRewriters(rules []string) ([]TopicRewriter, error) {
+	var ret []TopicRewriter
+	for _, rule := range rules {
+		r, err := NewTopicRewriter(rule)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// RewriteTopic applies the rewriters in order.
+func RewriteTopic(rewriters []TopicRewriter, topic string) string {
+	for _, r := range rewriters {
+		topic = r.Rewrite(topic)
+	}
+	return topic
+}
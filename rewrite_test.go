@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TopicRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	rewriters, err := createTopicRewriters([]string{
+		"strip site/",
+		"replace ^([^/]+)/Sensor-(\\d+) $1/sensor$2",
+		"lower",
+		"segments 1,0,2",
+	})
+	assert.Nil(err)
+	assert.Equal("sensor7/tokyo/temp", RewriteTopic(rewriters, "site/Tokyo/Sensor-7/TEMP"))
+
+	drop, err := NewTopicRewriter("drop 0,2")
+	assert.Nil(err)
+	assert.Equal("b/d", drop.Rewrite("a/b/c/d"))
+
+	// strip does not touch the topic if it is not the prefix
+	strip, err := NewTopicRewriter("strip site/")
+	assert.Nil(err)
+	assert.Equal("other/site/a", strip.Rewrite("other/site/a"))
+
+	for _, rule := range []string{"strip", "replace (", "segments a", "upper"} {
+		_, err := NewTopicRewriter(rule)
+		assert.NotNil(err, rule)
+	}
+}
+
+func Test_ReceiverRewrite(t *testing.T) {
+	assert := assert.New(t)
+
+	mqttChan := make(chan Message, 1)
+	r, err := newReceiver(MqttConf{
+		Topic:   "site/#",
+		Rewrite: []string{"lower"},
+	}, mqttChan)
+	assert.Nil(err)
+
+	r.receive(Message{Topic: "site/A/Temp"})
+	msg := <-mqttChan
+	assert.Equal("a/temp", msg.Topic)
+	assert.Equal("site/A/Temp", msg.OriginalTopic)
+}
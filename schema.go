@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SchemaConf declares the fields of a measurement, from a
+// [mqforward-schema "measurement"] section.
+type SchemaConf struct {
+	Field       []string // "name type" of a field: float, int, uint, bool or string
+	Required    []string // fields every point must have
+	Reject      bool     // rejects values of another type instead of converting them
+	DropUnknown bool     // drops fields which are not declared
+}
+
+type measurementSchema struct {
+	types       map[string]string
+	required    []string
+	reject      bool
+	dropUnknown bool
+}
+
+// Schema checks the fields of points against the declared field types of
+// their measurement. In learning mode, the first type of every other field is
+// recorded and enforced from then on, so that a message does not cause a
+// field type conflict in InfluxDB.
+type Schema struct {
+	measurements map[string]*measurementSchema
+	learn        bool
+
+	lock    sync.Mutex
+	learned map[string]map[string]string // type by measurement and field
+}
+
+func NewSchema(conf *InfluxDBConf) (*Schema, error) {
+	s := &Schema{
+		measurements: map[string]*measurementSchema{},
+		learn:        conf.SchemaLearn,
+		learned:      map[string]map[string]string{},
+	}
+	for name, c := range conf.Schemas {
+		m := &measurementSchema{
+			types:       map[string]string{},
+			required:    c.Required,
+			reject:      c.Reject,
+			dropUnknown: c.DropUnknown,
+		}
+		for _, f := range c.Field {
+			v := strings.Fields(f)
+			if len(v) != 2 {
+				return nil, fmt.Errorf("schema %s: field must be 'name type': %s", name, f)
+			}
+			if !validFieldType(v[1]) {
+				return nil, fmt.Errorf("schema %s: field %s: unknown type %q", name, v[0], v[1])
+			}
+			m.types[v[0]] = v[1]
+		}
+		for _, f := range m.required {
+			if _, ok := m.types[f]; !ok {
+				return nil, fmt.Errorf("schema %s: required field %s has no type", name, f)
+			}
+		}
+		s.measurements[name] = m
+	}
+	return s, nil
+}
+
+// Apply converts the fields to the types of the measurement. It returns an
+// error if the fields do not fit, and the point must be rejected.
+func (s *Schema) Apply(measurement string, fields map[string]interface{}) error {
+	m := s.measurements[measurement]
+	if m != nil {
+		for _, f := range m.required {
+			if _, ok := fields[f]; !ok {
+				return fmt.Errorf("%s: required field %s is missing", measurement, f)
+			}
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	learned := s.learned[measurement]
+	// the fields are learned only if the whole point fits
+	learn := map[string]string{}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fields[key]
+		if m != nil {
+			if typ, ok := m.types[key]; ok {
+				v, err := m.convert(value, typ)
+				if err != nil {
+					return fmt.Errorf("%s: field %s: %s", measurement, key, err)
+				}
+				fields[key] = v
+				continue
+			}
+			if m.dropUnknown {
+				delete(fields, key)
+				continue
+			}
+		}
+		if !s.learn {
+			continue
+		}
+		typ, ok := learned[key]
+		if !ok {
+			if typ = fieldType(value); typ != "" {
+				learn[key] = typ
+			}
+			continue
+		}
+		v, err := convertField(value, typ)
+		if err != nil {
+			return fmt.Errorf("%s: field %s: %s, the field is %s", measurement, key, err, typ)
+		}
+		fields[key] = v
+	}
+
+	if len(learn) > 0 {
+		if learned == nil {
+			learned = map[string]string{}
+			s.learned[measurement] = learned
+		}
+		for key, typ := range learn {
+			learned[key] = typ
+		}
+	}
+	return nil
+}
+
+func (m *measurementSchema) convert(value interface{}, typ string) (interface{}, error) {
+	if m.reject {
+		if t := fieldType(value); t != typ {
+			return nil, fmt.Errorf("type is %s instead of %s", t, typ)
+		}
+	}
+	return convertField(value, typ)
+}
+
+// fieldType returns the InfluxDB field type of a value.
+func fieldType(value interface{}) string {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Float32, reflect.Float64:
+		return FieldFloat
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return FieldInt
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return FieldUint
+	case reflect.Bool:
+		return FieldBool
+	case reflect.String:
+		return FieldString
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SchemaApply(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewSchema(&InfluxDBConf{
+		Schemas: map[string]*SchemaConf{
+			"weather": {
+				Field:    []string{"temp float", "count uint", "ok bool"},
+				Required: []string{"temp"},
+			},
+			"strict": {
+				Field:       []string{"temp float"},
+				Reject:      true,
+				DropUnknown: true,
+			},
+		},
+	})
+	assert.Nil(err)
+
+	fields := map[string]interface{}{"temp": int64(21), "count": float64(3), "ok": "true", "x": "y"}
+	assert.Nil(s.Apply("weather", fields))
+	assert.Equal(map[string]interface{}{
+		"temp": float64(21), "count": uint64(3), "ok": true, "x": "y",
+	}, fields)
+
+	assert.NotNil(s.Apply("weather", map[string]interface{}{"count": float64(3)}))
+	assert.NotNil(s.Apply("weather", map[string]interface{}{"temp": 1.5, "count": float64(-1)}))
+
+	fields = map[string]interface{}{"temp": 1.5, "x": "y"}
+	assert.Nil(s.Apply("strict", fields))
+	assert.Equal(map[string]interface{}{"temp": 1.5}, fields)
+	assert.NotNil(s.Apply("strict", map[string]interface{}{"temp": int64(1)}))
+
+	// without a schema, the fields are not checked
+	assert.Nil(s.Apply("other", map[string]interface{}{"x": "y"}))
+
+	_, err = NewSchema(&InfluxDBConf{
+		Schemas: map[string]*SchemaConf{"a": {Required: []string{"x"}}},
+	})
+	assert.NotNil(err)
+	_, err = NewSchema(&InfluxDBConf{
+		Schemas: map[string]*SchemaConf{"a": {Field: []string{"x decimal"}}},
+	})
+	assert.NotNil(err)
+}
+
+func Test_SchemaLearn(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewSchema(&InfluxDBConf{SchemaLearn: true})
+	assert.Nil(err)
+
+	assert.Nil(s.Apply("a", map[string]interface{}{"x": float64(1), "s": "on"}))
+
+	fields := map[string]interface{}{"x": int64(2), "s": "off"}
+	assert.Nil(s.Apply("a", fields))
+	assert.Equal(float64(2), fields["x"])
+
+	assert.NotNil(s.Apply("a", map[string]interface{}{"x": "high"}))
+	// other measurements learn their own types
+	assert.Nil(s.Apply("b", map[string]interface{}{"x": "high"}))
+}
+
+func Test_EncodeSchemaRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		Series: "weather",
+		Schemas: map[string]*SchemaConf{
+			"weather": {Field: []string{"temp float"}, Required: []string{"temp"}},
+		},
+	})
+	assert.Nil(err)
+
+	rejected := stats.rejected.Load()
+	assert.Equal(1, len(coder.Encode(Message{Topic: "a", Payload: []byte(`{"temp": 21}`)})))
+	assert.Equal(0, len(coder.Encode(Message{Topic: "a", Payload: []byte(`{"hum": 50}`)})))
+	assert.Equal(rejected+1, stats.rejected.Load())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// SenML records with a time below 2^28 are relative to the current time.
+const senmlRelativeTime = 1 << 28
+
+// SenML labels of the CBOR representation, RFC 8428 section 6
+var senmlCBORLabels = map[string]string{
+	"-1": "bver",
+	"-2": "bn",
+	"-3": "bt",
+	"-4": "bu",
+	"-5": "bv",
+	"-6": "bs",
+	"0":  "n",
+	"1":  "u",
+	"2":  "v",
+	"3":  "vs",
+	"4":  "vb",
+	"5":  "s",
+	"6":  "t",
+	"7":  "ut",
+	"8":  "vd",
+}
+
+// SenMLDecoder decodes SenML packs (RFC 8428) in JSON or CBOR. By default the
+// records of a time become the fields of one record, named by the resolved
+// record names. With points, every SenML record becomes a record with a
+// "name" tag and a "value" field.
+type SenMLDecoder struct {
+	points  bool
+	unitTag string // tag of the unit, not stored if empty
+}
+
+func NewSenMLDecoder(f *FormatConf) *SenMLDecoder {
+	return &SenMLDecoder{
+		points:  f.SenmlPoints,
+		unitTag: f.UnitTag,
+	}
+}
+
+func (d *SenMLDecoder) ContentType() string {
+	return "application/senml+json"
+}
+
+// senmlRecord is a SenML record with the base values applied.
+type senmlRecord struct {
+	name  string
+	unit  string
+	time  time.Time
+	value interface{}
+}
+
+func (d *SenMLDecoder) Decode(msg Message) ([]Record, error) {
+	pack, err := parseSenML(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	records, err := resolveSenML(pack, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if d.points {
+		ret := make([]Record, 0, len(records))
+		for _, s := range records {
+			r := Record{
+				Time:   s.time,
+				Tags:   map[string]string{"name": s.name},
+				Fields: map[string]interface{}{"value": s.value},
+			}
+			if d.unitTag != "" && s.unit != "" {
+				r.Tags[d.unitTag] = s.unit
+			}
+			ret = append(ret, r)
+		}
+		return ret, nil
+	}
+
+	// records of the same time and unit share a record
+	type key struct {
+		time time.Time
+		unit string
+	}
+	keys := []key{}
+	byKey := map[key]*Record{}
+	for _, s := range records {
+		k := key{time: s.time}
+		if d.unitTag != "" {
+			k.unit = s.unit
+		}
+		r, ok := byKey[k]
+		if !ok {
+			r = &Record{Time: s.time, Fields: map[string]interface{}{}}
+			if k.unit != "" {
+				r.Tags = map[string]string{d.unitTag: k.unit}
+			}
+			byKey[k] = r
+			keys = append(keys, k)
+		}
+		r.Fields[s.name] = s.value
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].time.Before(keys[j].time) })
+
+	ret := make([]Record, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, *byKey[k])
+	}
+	return ret, nil
+}
+
+// parseSenML decodes a JSON or CBOR pack into records with JSON labels.
+func parseSenML(payload []byte) ([]map[string]interface{}, error) {
+	var v interface{}
+	if p := bytes.TrimSpace(payload); len(p) > 0 && p[0] == '[' {
+		if err := json.Unmarshal(p, &v); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if v, err = decodeCBOR(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	array, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("senml: pack is not an array")
+	}
+	pack := make([]map[string]interface{}, 0, len(array))
+	for _, e := range array {
+		m, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("senml: record is not a map")
+		}
+		record := make(map[string]interface{}, len(m))
+		for k, value := range m {
+			if label, ok := senmlCBORLabels[k]; ok {
+				k = label
+			}
+			record[k] = value
+		}
+		pack = append(pack, record)
+	}
+	return pack, nil
+}
+
+// resolveSenML applies the base fields to the records. Base fields apply to
+// the record they are in and all the records after it.
+func resolveSenML(pack []map[string]interface{}, now time.Time) ([]senmlRecord, error) {
+	var (
+		baseName  string
+		baseTime  float64
+		baseUnit  string
+		baseValue float64
+		baseSum   float64
+	)
+
+	ret := []senmlRecord{}
+	for i, r := range pack {
+		if v, ok := r["bn"].(string); ok {
+			baseName = v
+		}
+		if v, ok := number(r["bt"]); ok {
+			baseTime = v
+		}
+		if v, ok := r["bu"].(string); ok {
+			baseUnit = v
+		}
+		if v, ok := number(r["bv"]); ok {
+			baseValue = v
+		}
+		if v, ok := number(r["bs"]); ok {
+			baseSum = v
+		}
+
+		name, _ := r["n"].(string)
+		name = baseName + name
+		if name == "" {
+			return nil, fmt.Errorf("senml: record %d has no name", i)
+		}
+
+		s := senmlRecord{name: name, unit: baseUnit}
+		if u, ok := r["u"].(string); ok {
+			s.unit = u
+		}
+
+		t, _ := number(r["t"])
+		t += baseTime
+		switch {
+		case t == 0:
+			// receive time
+		case t < senmlRelativeTime:
+			s.time = now.Add(time.Duration(t * float64(time.Second)))
+		default:
+			sec, frac := math.Modf(t)
+			s.time = time.Unix(int64(sec), int64(frac*1e9))
+		}
+
+		if v, ok := number(r["v"]); ok {
+			s.value = baseValue + v
+		} else if v, ok := r["vs"].(string); ok {
+			s.value = v
+		} else if v, ok := r["vb"].(bool); ok {
+			s.value = v
+		} else if v, ok := r["vd"]; ok {
+			s.value = senmlData(v)
+		} else if v, ok := number(r["s"]); ok {
+			s.value = baseSum + v
+		} else {
+			// records with only base fields
+			continue
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+// senmlData returns data values as base64 URL strings, as in SenML JSON.
+func senmlData(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return v
+}
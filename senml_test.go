@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SenMLDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := []byte(`[
+		{"bn": "urn:dev:ow:10e2073a01080063:", "bt": 1600000000, "bu": "Cel", "n": "temp", "v": 23.1},
+		{"n": "hum", "u": "%RH", "v": 40},
+		{"n": "temp", "t": 10, "v": 23.5},
+		{"n": "door", "vb": true, "t": 10}
+	]`)
+
+	d := NewSenMLDecoder(&FormatConf{})
+	records, err := d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal([]Record{
+		{
+			Time: time.Unix(1600000000, 0),
+			Fields: map[string]interface{}{
+				"urn:dev:ow:10e2073a01080063:temp": 23.1,
+				"urn:dev:ow:10e2073a01080063:hum":  float64(40),
+			},
+		},
+		{
+			Time: time.Unix(1600000010, 0),
+			Fields: map[string]interface{}{
+				"urn:dev:ow:10e2073a01080063:temp": 23.5,
+				"urn:dev:ow:10e2073a01080063:door": true,
+			},
+		},
+	}, records)
+
+	d = NewSenMLDecoder(&FormatConf{SenmlPoints: true, UnitTag: "unit"})
+	records, err = d.Decode(Message{Payload: payload})
+	assert.Nil(err)
+	assert.Equal(4, len(records))
+	assert.Equal(Record{
+		Time:   time.Unix(1600000000, 0),
+		Tags:   map[string]string{"name": "urn:dev:ow:10e2073a01080063:hum", "unit": "%RH"},
+		Fields: map[string]interface{}{"value": float64(40)},
+	}, records[1])
+
+	// relative times
+	records, err = d.Decode(Message{Payload: []byte(`[{"n": "a", "v": 1, "t": -60}]`)})
+	assert.Nil(err)
+	assert.WithinDuration(time.Now().Add(-time.Minute), records[0].Time, time.Second)
+
+	_, err = d.Decode(Message{Payload: []byte(`[{"v": 1}]`)})
+	assert.NotNil(err)
+	_, err = d.Decode(Message{Payload: []byte(`{"n": "a", "v": 1}`)})
+	assert.NotNil(err)
+}
+
+func Test_SenMLCBOR(t *testing.T) {
+	assert := assert.New(t)
+
+	payload, err := cbor.Marshal([]map[int]interface{}{
+		{-2: "dev:", -3: 1600000000, 0: "temp", 2: 21.5},
+		{0: "raw", 8: []byte{1, 2}},
+	})
+	assert.Nil(err)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{})
+	assert.Nil(err)
+	records, d, err := r.Decode(Message{ContentType: "application/senml+cbor", Payload: payload})
+	assert.Nil(err)
+	assert.Equal("application/senml+json", d.ContentType())
+	assert.Equal([]Record{{
+		Time:   time.Unix(1600000000, 0),
+		Fields: map[string]interface{}{"dev:temp": 21.5, "dev:raw": "AQI"},
+	}}, records)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Sparkplug B topics are spBv1.0/<group>/<message type>/<node>[/<device>]
+const SparkplugNamespace = "spBv1.0"
+
+// Sparkplug B metric data types
+const (
+	spInt8     = 1
+	spInt16    = 2
+	spInt32    = 3
+	spInt64    = 4
+	spUInt8    = 5
+	spUInt16   = 6
+	spUInt32   = 7
+	spUInt64   = 8
+	spFloat    = 9
+	spDouble   = 10
+	spBoolean  = 11
+	spString   = 12
+	spDateTime = 13
+	spText     = 14
+	spUUID     = 15
+)
+
+// Sparkplug B protobuf field numbers
+const (
+	spPayloadTimestamp = 1
+	spPayloadMetrics   = 2
+
+	spMetricName      = 1
+	spMetricAlias     = 2
+	spMetricTimestamp = 3
+	spMetricDatatype  = 4
+	spMetricIsNull    = 7
+	spMetricInt       = 10
+	spMetricLong      = 11
+	spMetricFloat     = 12
+	spMetricDouble    = 13
+	spMetricBoolean   = 14
+	spMetricString    = 15
+)
+
+// SparkplugTopic is a parsed Sparkplug B topic.
+type SparkplugTopic struct {
+	Group       string
+	MessageType string
+	Node        string
+	Device      string
+}
+
+// ParseSparkplugTopic parses topic if it is in the Sparkplug B namespace.
+func ParseSparkplugTopic(topic string) (SparkplugTopic, bool) {
+	v := strings.Split(topic, MqttSeparator)
+	if len(v) < 4 || len(v) > 5 || v[0] != SparkplugNamespace {
+		return SparkplugTopic{}, false
+	}
+	t := SparkplugTopic{
+		Group:       v[1],
+		MessageType: v[2],
+		Node:        v[3],
+	}
+	if len(v) == 5 {
+		t.Device = v[4]
+	}
+	return t, true
+}
+
+// Measurement returns the group, node and device joined like a topic name,
+// without the message type so that the BIRTH and DATA messages of a node or
+// device share the measurement.
+func (t SparkplugTopic) Measurement() string {
+	name := t.Group + "." + t.Node
+	if t.Device != "" {
+		name += "." + t.Device
+	}
+	return name
+}
+
+// Tags returns the group, node and device as tags.
+func (t SparkplugTopic) Tags() map[string]string {
+	tags := map[string]string{
+		"group": t.Group,
+		"node":  t.Node,
+	}
+	if t.Device != "" {
+		tags["device"] = t.Device
+	}
+	return tags
+}
+
+type sparkplugMetric struct {
+	name      string
+	alias     uint64
+	hasAlias  bool
+	timestamp uint64
+	datatype  uint32
+	isNull    bool
+	value     interface{} // raw value of the set value field
+}
+
+type sparkplugAlias struct {
+	name     string
+	datatype uint32
+}
+
+// SparkplugDecoder decodes Sparkplug B payloads. It keeps the alias table of
+// each edge node from its NBIRTH and DBIRTH messages to resolve the metric
+// aliases of the DATA messages.
+type SparkplugDecoder struct {
+	lock    sync.Mutex
+	aliases map[string]map[uint64]sparkplugAlias // by group/node
+	types   map[string]map[string]uint32         // datatype by group/node and metric name
+}
+
+func NewSparkplugDecoder() *SparkplugDecoder {
+	return &SparkplugDecoder{
+		aliases: map[string]map[uint64]sparkplugAlias{},
+		types:   map[string]map[string]uint32{},
+	}
+}
+
+func (d *SparkplugDecoder) ContentType() string {
+	return "application/vnd.eclipse.sparkplug-b"
+}
+
+// Detect reports whether the message is in the Sparkplug B namespace.
+func (d *SparkplugDecoder) Detect(msg Message) bool {
+	_, ok := ParseSparkplugTopic(msg.PublishedTopic())
+	return ok
+}
+
+func (d *SparkplugDecoder) Decode(msg Message) ([]Record, error) {
+	topic, ok := ParseSparkplugTopic(msg.PublishedTopic())
+	if !ok {
+		return nil, fmt.Errorf("not a sparkplug topic: %s", msg.PublishedTopic())
+	}
+	return d.DecodeTopic(topic, msg.Payload)
+}
+
+// DecodeTopic decodes a Sparkplug B message into one record per metric
+// timestamp. Only BIRTH and DATA messages have records.
+func (d *SparkplugDecoder) DecodeTopic(topic SparkplugTopic, payload []byte) ([]Record, error) {
+	node := topic.Group + MqttSeparator + topic.Node
+
+	switch topic.MessageType {
+	case "NBIRTH", "DBIRTH", "NDATA", "DDATA":
+	case "NDEATH":
+		d.lock.Lock()
+		delete(d.aliases, node)
+		delete(d.types, node)
+		d.lock.Unlock()
+		return nil, nil
+	default:
+		return nil, nil
+	}
+
+	timestamp, metrics, err := parseSparkplugPayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("sparkplug: %s", err)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if topic.MessageType == "NBIRTH" {
+		// a new session of the node starts with a new alias table
+		d.aliases[node] = map[uint64]sparkplugAlias{}
+		d.types[node] = map[string]uint32{}
+	}
+	aliases := d.aliases[node]
+	types := d.types[node]
+	birth := strings.HasSuffix(topic.MessageType, "BIRTH")
+	if birth && aliases == nil {
+		aliases = map[uint64]sparkplugAlias{}
+		types = map[string]uint32{}
+		d.aliases[node] = aliases
+		d.types[node] = types
+	}
+
+	byTime := map[uint64]map[string]interface{}{}
+	for _, m := range metrics {
+		if birth && m.name != "" {
+			if m.hasAlias {
+				aliases[m.alias] = sparkplugAlias{name: m.name, datatype: m.datatype}
+			}
+			types[m.name] = m.datatype
+		}
+		if m.name == "" && m.hasAlias {
+			a, ok := aliases[m.alias]
+			if !ok {
+				continue
+			}
+			m.name = a.name
+			if m.datatype == 0 {
+				m.datatype = a.datatype
+			}
+		}
+		if m.name == "" || m.isNull {
+			continue
+		}
+		if m.datatype == 0 {
+			m.datatype = types[m.name]
+		}
+		value, ok := sparkplugValue(m.datatype, m.value)
+		if !ok {
+			continue
+		}
+
+		ts := m.timestamp
+		if ts == 0 {
+			ts = timestamp
+		}
+		if byTime[ts] == nil {
+			byTime[ts] = map[string]interface{}{}
+		}
+		byTime[ts][m.name] = value
+	}
+
+	stamps := make([]uint64, 0, len(byTime))
+	for ts := range byTime {
+		stamps = append(stamps, ts)
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] < stamps[j] })
+
+	ret := []Record{}
+	for _, ts := range stamps {
+		r := Record{
+			Measurement: topic.Measurement(),
+			Tags:        topic.Tags(),
+			Fields:      byTime[ts],
+		}
+		if ts != 0 {
+			r.Time = time.UnixMilli(int64(ts))
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// sparkplugValue converts the raw value to the Go type of the data type. If
+// the data type is unknown, the raw value is used.
+func sparkplugValue(datatype uint32, raw interface{}) (interface{}, bool) {
+	switch v := raw.(type) {
+	case uint64:
+		switch datatype {
+		case spInt8, spInt16, spInt32:
+			return int64(int32(uint32(v))), true
+		case spInt64, spDateTime:
+			return int64(v), true
+		case spUInt8, spUInt16, spUInt32, spUInt64:
+			return v, true
+		case spBoolean:
+			return v != 0, true
+		}
+		return int64(v), true
+	case float32:
+		return float64(v), true
+	case float64, bool:
+		return v, true
+	case string:
+		switch datatype {
+		case 0, spString, spText, spUUID:
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func parseSparkplugPayload(b []byte) (uint64, []sparkplugMetric, error) {
+	var timestamp uint64
+	metrics := []sparkplugMetric{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == spPayloadTimestamp && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, nil, protowire.ParseError(n)
+			}
+			timestamp = v
+			b = b[n:]
+		case num == spPayloadMetrics && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return 0, nil, protowire.ParseError(n)
+			}
+			m, err := parseSparkplugMetric(v)
+			if err != nil {
+				return 0, nil, err
+			}
+			metrics = append(metrics, m)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return 0, nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return timestamp, metrics, nil
+}
+
+func parseSparkplugMetric(b []byte) (sparkplugMetric, error) {
+	m := sparkplugMetric{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case spMetricAlias:
+				m.alias = v
+				m.hasAlias = true
+			case spMetricTimestamp:
+				m.timestamp = v
+			case spMetricDatatype:
+				m.datatype = uint32(v)
+			case spMetricIsNull:
+				m.isNull = v != 0
+			case spMetricInt, spMetricLong:
+				m.value = v
+			case spMetricBoolean:
+				m.value = protowire.DecodeBool(v)
+			}
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == spMetricFloat {
+				m.value = math.Float32frombits(v)
+			}
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == spMetricDouble {
+				m.value = math.Float64frombits(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case spMetricName:
+				m.name = string(v)
+			case spMetricString:
+				m.value = string(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return m, nil
+}
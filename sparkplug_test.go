@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// appendSparkplugMetric appends a metric with a double or int value.
+func appendSparkplugMetric(b []byte, name string, alias uint64, datatype uint32, ts uint64, value interface{}) []byte {
+	var m []byte
+	if name != "" {
+		m = protowire.AppendTag(m, spMetricName, protowire.BytesType)
+		m = protowire.AppendString(m, name)
+	}
+	m = protowire.AppendTag(m, spMetricAlias, protowire.VarintType)
+	m = protowire.AppendVarint(m, alias)
+	if ts != 0 {
+		m = protowire.AppendTag(m, spMetricTimestamp, protowire.VarintType)
+		m = protowire.AppendVarint(m, ts)
+	}
+	if datatype != 0 {
+		m = protowire.AppendTag(m, spMetricDatatype, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(datatype))
+	}
+	switch v := value.(type) {
+	case float64:
+		m = protowire.AppendTag(m, spMetricDouble, protowire.Fixed64Type)
+		m = protowire.AppendFixed64(m, math.Float64bits(v))
+	case int32:
+		m = protowire.AppendTag(m, spMetricInt, protowire.VarintType)
+		m = protowire.AppendVarint(m, uint64(uint32(v)))
+	}
+	b = protowire.AppendTag(b, spPayloadMetrics, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func Test_SparkplugDecoder(t *testing.T) {
+	assert := assert.New(t)
+	d := NewSparkplugDecoder()
+
+	var birth []byte
+	birth = protowire.AppendTag(birth, spPayloadTimestamp, protowire.VarintType)
+	birth = protowire.AppendVarint(birth, 1000)
+	birth = appendSparkplugMetric(birth, "temp", 1, spDouble, 0, float64(20.5))
+	birth = appendSparkplugMetric(birth, "level", 2, spInt16, 0, int32(-3))
+
+	topic, ok := ParseSparkplugTopic("spBv1.0/plant/DBIRTH/edge1/pump")
+	assert.True(ok)
+	records, err := d.DecodeTopic(topic, birth)
+	assert.Nil(err)
+	assert.Equal(1, len(records))
+	assert.Equal(time.UnixMilli(1000), records[0].Time)
+	assert.Equal(map[string]interface{}{"temp": 20.5, "level": int64(-3)}, records[0].Fields)
+	assert.Equal(map[string]string{"group": "plant", "node": "edge1", "device": "pump"}, records[0].Tags)
+
+	// DATA metrics have only aliases and their own timestamps
+	var data []byte
+	data = protowire.AppendTag(data, spPayloadTimestamp, protowire.VarintType)
+	data = protowire.AppendVarint(data, 3000)
+	data = appendSparkplugMetric(data, "", 1, 0, 2000, float64(21))
+	data = appendSparkplugMetric(data, "", 2, 0, 0, int32(4))
+	data = appendSparkplugMetric(data, "", 9, 0, 0, int32(1)) // unknown alias
+
+	topic, _ = ParseSparkplugTopic("spBv1.0/plant/DDATA/edge1/pump")
+	records, err = d.DecodeTopic(topic, data)
+	assert.Nil(err)
+	assert.Equal(2, len(records))
+	assert.Equal(time.UnixMilli(2000), records[0].Time)
+	assert.Equal(map[string]interface{}{"temp": float64(21)}, records[0].Fields)
+	assert.Equal(time.UnixMilli(3000), records[1].Time)
+	assert.Equal(map[string]interface{}{"level": int64(4)}, records[1].Fields)
+
+	// the alias table is gone with the node
+	topic, _ = ParseSparkplugTopic("spBv1.0/plant/NDEATH/edge1")
+	_, err = d.DecodeTopic(topic, nil)
+	assert.Nil(err)
+	topic, _ = ParseSparkplugTopic("spBv1.0/plant/DDATA/edge1/pump")
+	records, err = d.DecodeTopic(topic, data)
+	assert.Nil(err)
+	assert.Equal(0, len(records))
+
+	_, ok = ParseSparkplugTopic("plant/DDATA/edge1/pump")
+	assert.False(ok)
+}
+
+func Test_EncodeSparkplug(t *testing.T) {
+	assert := assert.New(t)
+
+	var birth []byte
+	birth = appendSparkplugMetric(birth, "temp", 1, spDouble, 5000, float64(20.5))
+	msg := Message{
+		Topic:         "plant/NBIRTH/edge1",
+		OriginalTopic: "spBv1.0/plant/NBIRTH/edge1",
+		Payload:       birth,
+	}
+	conf := &InfluxDBConf{
+		Db:         "db",
+		NoTopicTag: true,
+		Series:     "plc",
+	}
+	coder, err := NewMqttSeriesEncoder(conf)
+	assert.Nil(err)
+
+	points := coder.Encode(msg)
+	assert.Equal(1, len(points))
+	assert.Equal("plc", points[0].Name())
+	assert.Equal(time.UnixMilli(5000), points[0].Time())
+	assert.Equal(2, len(points[0].TagList()))
+}
+
+func Test_EncodeSparkplugMeasurement(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{Db: "db"})
+	assert.Nil(err)
+
+	var birth []byte
+	birth = appendSparkplugMetric(birth, "temp", 1, spDouble, 5000, float64(20.5))
+	points := coder.Encode(Message{Topic: "spBv1.0/plant/DBIRTH/edge1/pump", Payload: birth})
+	assert.Equal(1, len(points))
+	assert.Equal("plant.edge1.pump", points[0].Name())
+
+	var data []byte
+	data = appendSparkplugMetric(data, "", 1, 0, 6000, float64(21))
+	points = coder.Encode(Message{Topic: "spBv1.0/plant/DDATA/edge1/pump", Payload: data})
+	assert.Equal(1, len(points))
+	assert.Equal("plant.edge1.pump", points[0].Name())
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// Stats counts the messages handled by the forwarder, not the points written
+// for them. It is safe for concurrent use.
+type Stats struct {
+	received   atomic.Uint64
+	written    atomic.Uint64 // handed to the asynchronous writer, or written when acknowledged
+	dropped    atomic.Uint64
+	rejected   atomic.Uint64 // messages which do not fit the schema, also dropped
+	unverified atomic.Uint64 // messages which fail verification or decryption, also dropped
+
+	writeErrors atomic.Uint64 // failed asynchronous writes of a batch, retries included
+}
+
+// StatsSnapshot is a copy of the counters of Stats.
+type StatsSnapshot struct {
+	Received   uint64 `json:"received"`
+	Written    uint64 `json:"written"`
+	Dropped    uint64 `json:"dropped"`
+	Rejected   uint64 `json:"rejected"`
+	Unverified uint64 `json:"unverified"`
+
+	WriteErrors uint64 `json:"writeErrors"`
+}
+
+// stats is shared by the MQTT client, the encoder and the InfluxDB client.
+var stats Stats
+
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Received:   s.received.Load(),
+		Written:    s.written.Load(),
+		Dropped:    s.dropped.Load(),
+		Rejected:   s.rejected.Load(),
+		Unverified: s.unverified.Load(),
+
+		WriteErrors: s.writeErrors.Load(),
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultTimeField = "time"
+
+	TimeAuto    = ""        // unix time in a unit guessed by magnitude, or RFC3339
+	TimeRFC3339 = "rfc3339" // RFC3339 with optional fractional seconds
+	TimeUnix    = "unix"
+	TimeUnixMs  = "unix_ms"
+	TimeUnixUs  = "unix_us"
+	TimeUnixNs  = "unix_ns"
+)
+
+// Timestamps InfluxDB can store, as nanoseconds since the epoch in an int64.
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// TimeParser reads the timestamp of a record from a payload field, if a time
+// field or a time format is configured. Any other time format is a layout of
+// time.Parse.
+type TimeParser struct {
+	fields    []string // tried in order
+	override  bool     // the fields override a time decoded from the payload
+	format    string
+	location  *time.Location // of layouts without time zone
+	maxFuture time.Duration  // 0 is unlimited
+	maxPast   time.Duration  // 0 is unlimited
+}
+
+func NewTimeParser(conf *InfluxDBConf) (*TimeParser, error) {
+	p := &TimeParser{
+		format:    conf.TimeFormat,
+		location:  time.UTC,
+		maxFuture: time.Duration(conf.TimeMaxFuture) * time.Second,
+		maxPast:   time.Duration(conf.TimeMaxPast) * time.Second,
+		override:  conf.TimeField != "" || conf.BatchTime != "",
+	}
+	// elements of batch payloads may have their own time field
+	if conf.BatchTime != "" {
+		p.fields = append(p.fields, conf.BatchTime)
+	}
+	// without configuration, a time field is written as it was before
+	if conf.TimeField != "" {
+		p.fields = append(p.fields, conf.TimeField)
+	} else if conf.TimeFormat != "" {
+		p.fields = append(p.fields, DefaultTimeField)
+	}
+	if conf.TimeZone != "" {
+		loc, err := time.LoadLocation(conf.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("time zone: %s", err)
+		}
+		p.location = loc
+	}
+	if p.maxFuture < 0 || p.maxPast < 0 {
+		return nil, fmt.Errorf("time max future and max past must not be negative")
+	}
+	return p, nil
+}
+
+// Extract sets the time of the record from the first time field which can be
+// parsed and removes the field. Otherwise the record keeps its time and the
+// fields, and the receive time is used if it has none. A time decoded from
+// the payload is kept unless a time field or a batch time field is
+// configured. It returns an error if the time is out of the accepted range.
+func (p *TimeParser) Extract(r *Record, now time.Time) error {
+	if !r.Time.IsZero() && (r.Time.Before(minTime) || r.Time.After(maxTime)) {
+		log.Debugf("decoded timestamp %s is out of range", r.Time)
+		r.Time = time.Time{}
+	}
+	if !r.Time.IsZero() && !p.override {
+		return p.Check(r.Time, now)
+	}
+	for _, field := range p.fields {
+		v, ok := r.Fields[field]
+		if !ok {
+			continue
+		}
+		t, err := p.Parse(v)
+		if err != nil {
+			log.Debugf("time field %s: %s", field, err)
+			continue
+		}
+		r.Time = t
+		delete(r.Fields, field)
+		break
+	}
+	return p.Check(r.Time, now)
+}
+
+// Check returns an error if t is too far in the future or in the past of now.
+// A zero time is the receive time and always accepted.
+func (p *TimeParser) Check(t time.Time, now time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+	if p.maxFuture > 0 && t.Sub(now) > p.maxFuture {
+		return fmt.Errorf("timestamp %s is too far in the future", t.Format(time.RFC3339))
+	}
+	if p.maxPast > 0 && now.Sub(t) > p.maxPast {
+		return fmt.Errorf("timestamp %s is too far in the past", t.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Parse parses a decoded value in the time format. It returns an error if the
+// time can not be stored in InfluxDB.
+func (p *TimeParser) Parse(v interface{}) (time.Time, error) {
+	t, err := p.parse(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if t.Before(minTime) || t.After(maxTime) {
+		return time.Time{}, fmt.Errorf("timestamp %s is out of range", t.Format(time.RFC3339))
+	}
+	return t, nil
+}
+
+func (p *TimeParser) parse(v interface{}) (time.Time, error) {
+	switch p.format {
+	case TimeAuto:
+		if s, ok := v.(string); ok {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		return unixTime(v, unixUnit(v))
+	case TimeRFC3339:
+		s, ok := v.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("timestamp is not a string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case TimeUnix:
+		return unixTime(v, time.Second)
+	case TimeUnixMs:
+		return unixTime(v, time.Millisecond)
+	case TimeUnixUs:
+		return unixTime(v, time.Microsecond)
+	case TimeUnixNs:
+		return unixTime(v, time.Nanosecond)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("timestamp is not a string")
+	}
+	return time.ParseInLocation(p.format, s, p.location)
+}
+
+// unixTime converts a number or a numeric string of units since the epoch.
+func unixTime(v interface{}, unit time.Duration) (time.Time, error) {
+	if n, ok := v.(json.Number); ok {
+		v = string(n)
+	}
+	if s, ok := v.(string); ok {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			v = n
+		} else if f, err := strconv.ParseFloat(s, 64); err == nil {
+			v = f
+		} else {
+			return time.Time{}, fmt.Errorf("timestamp %q is not a number", s)
+		}
+	}
+
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return unixUnits(r.Int(), unit), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if r.Uint() > math.MaxInt64 {
+			return time.Time{}, fmt.Errorf("timestamp %d is out of range", r.Uint())
+		}
+		return unixUnits(int64(r.Uint()), unit), nil
+	}
+	f, ok := number(v)
+	if !ok {
+		return time.Time{}, fmt.Errorf("timestamp is not a number")
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		// exact for whole JSON numbers up to 2^53
+		return unixUnits(int64(f), unit), nil
+	}
+	sec, frac := math.Modf(f * float64(unit) / float64(time.Second))
+	if math.Abs(sec) >= 1<<62 {
+		return time.Time{}, fmt.Errorf("timestamp %g is out of range", f)
+	}
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+// unixUnit guesses the unit of a unix time by its magnitude: seconds up to
+// the year 5138, then milliseconds, microseconds and nanoseconds.
+func unixUnit(v interface{}) time.Duration {
+	f, _ := number(v)
+	switch f = math.Abs(f); {
+	case f < 1e11:
+		return time.Second
+	case f < 1e14:
+		return time.Millisecond
+	case f < 1e17:
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
+
+func unixUnits(n int64, unit time.Duration) time.Time {
+	per := int64(time.Second / unit)
+	return time.Unix(n/per, n%per*int64(unit))
+}
+
+// number returns a decoded numeric value as float64.
+func number(v interface{}) (float64, bool) {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	r := reflect.ValueOf(v)
+	switch r.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(r.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(r.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return r.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_TimeParserParse(t *testing.T) {
+	assert := assert.New(t)
+
+	expected := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		conf  InfluxDBConf
+		value interface{}
+	}{
+		{InfluxDBConf{}, float64(1609556645)},
+		{InfluxDBConf{}, float64(1609556645000)},
+		{InfluxDBConf{}, int64(1609556645000000)},
+		{InfluxDBConf{}, uint64(1609556645000000000)},
+		{InfluxDBConf{}, "2021-01-02T03:04:05Z"},
+		{InfluxDBConf{TimeFormat: "rfc3339"}, "2021-01-02T12:04:05+09:00"},
+		{InfluxDBConf{TimeFormat: "unix"}, "1609556645"},
+		{InfluxDBConf{TimeFormat: "unix_ms"}, int64(1609556645000)},
+		{InfluxDBConf{TimeFormat: "unix_us"}, float64(1609556645000000)},
+		{InfluxDBConf{TimeFormat: "unix_ns"}, int64(1609556645000000000)},
+		{InfluxDBConf{TimeFormat: "2006-01-02 15:04:05", TimeZone: "Asia/Tokyo"}, "2021-01-02 12:04:05"},
+	}
+	for i, c := range cases {
+		p, err := NewTimeParser(&c.conf)
+		assert.Nil(err)
+		ts, err := p.Parse(c.value)
+		assert.Nil(err, "case %d", i)
+		assert.True(expected.Equal(ts), "case %d: %s", i, ts)
+	}
+
+	p, err := NewTimeParser(&InfluxDBConf{TimeFormat: "unix_ms"})
+	assert.Nil(err)
+	ts, err := p.Parse(int64(1500))
+	assert.Nil(err)
+	assert.Equal(time.Unix(1, 5e8), ts)
+	_, err = p.Parse("yesterday")
+	assert.NotNil(err)
+
+	// times InfluxDB can not store
+	for _, v := range []interface{}{float64(1e300), "3000-01-01T00:00:00Z"} {
+		p, err := NewTimeParser(&InfluxDBConf{})
+		assert.Nil(err)
+		_, err = p.Parse(v)
+		assert.NotNil(err, "%v", v)
+	}
+	p, err = NewTimeParser(&InfluxDBConf{TimeFormat: "unix"})
+	assert.Nil(err)
+	_, err = p.Parse(int64(1700000000000))
+	assert.NotNil(err)
+
+	_, err = NewTimeParser(&InfluxDBConf{TimeZone: "Nowhere/City"})
+	assert.NotNil(err)
+}
+
+func Test_TimeParserExtract(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1600000000, 0)
+	p, err := NewTimeParser(&InfluxDBConf{TimeField: "ts", TimeMaxFuture: 60, TimeMaxPast: 3600})
+	assert.Nil(err)
+
+	r := Record{Fields: map[string]interface{}{"ts": float64(1600000030), "x": 1}}
+	assert.Nil(p.Extract(&r, now))
+	assert.Equal(time.Unix(1600000030, 0), r.Time)
+	assert.Equal(map[string]interface{}{"x": 1}, r.Fields)
+
+	// unparsable timestamps fall back to the receive time
+	r = Record{Fields: map[string]interface{}{"ts": true}}
+	assert.Nil(p.Extract(&r, now))
+	assert.True(r.Time.IsZero())
+	assert.Equal(true, r.Fields["ts"])
+
+	r = Record{Fields: map[string]interface{}{"ts": float64(1e300)}}
+	assert.Nil(p.Extract(&r, now))
+	assert.True(r.Time.IsZero())
+	assert.Equal(float64(1e300), r.Fields["ts"])
+
+	r = Record{Fields: map[string]interface{}{"ts": float64(1600000061)}}
+	assert.NotNil(p.Extract(&r, now))
+	r = Record{Fields: map[string]interface{}{"ts": float64(1600000000 - 3601)}}
+	assert.NotNil(p.Extract(&r, now))
+
+	// a configured time field overrides the decoded time
+	decoded := time.Unix(1600000010, 0)
+	r = Record{Time: decoded, Fields: map[string]interface{}{"ts": float64(1600000020)}}
+	assert.Nil(p.Extract(&r, now))
+	assert.Equal(time.Unix(1600000020, 0), r.Time)
+
+	// with only a time format, the decoded time wins and the field is kept
+	p, err = NewTimeParser(&InfluxDBConf{TimeFormat: TimeUnix})
+	assert.Nil(err)
+	r = Record{Time: decoded, Fields: map[string]interface{}{"time": float64(1600000020)}}
+	assert.Nil(p.Extract(&r, now))
+	assert.Equal(decoded, r.Time)
+	assert.Equal(float64(1600000020), r.Fields["time"])
+}
+
+func Test_EncodeTime(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{TimeFormat: "unix_ms", TimeMaxFuture: 3600})
+	assert.Nil(err)
+
+	points := coder.Encode(Message{Topic: "a", Payload: []byte(`{"time": 1600000000123, "x": 1}`)})
+	assert.Equal(1, len(points))
+	assert.Equal(time.Unix(1600000000, 123e6), points[0].Time())
+	assert.Equal(1, len(points[0].FieldList()))
+
+	// without a time field or format, the time field is kept
+	coder, err = NewMqttSeriesEncoder(&InfluxDBConf{})
+	assert.Nil(err)
+	for _, payload := range []string{`{"time": 1700000000000, "x": 1}`, `{"time": 5, "x": 1}`} {
+		points = coder.Encode(Message{Topic: "a", Payload: []byte(payload)})
+		assert.Equal(1, len(points))
+		assert.Equal(2, len(points[0].FieldList()))
+		assert.Equal("_time", points[0].FieldList()[0].Key)
+		assert.WithinDuration(time.Now(), points[0].Time(), time.Minute)
+	}
+
+	coder, err = NewMqttSeriesEncoder(&InfluxDBConf{TimeFormat: "unix_ms", TimeMaxFuture: 3600})
+	assert.Nil(err)
+	future := time.Now().Add(2*time.Hour).UnixNano() / 1e6
+	points = coder.Encode(Message{
+		Topic:   "a",
+		Payload: []byte(`{"time": ` + strconv.FormatInt(future, 10) + `, "x": 1}`),
+	})
+	assert.Equal(0, len(points))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	gcfg "gopkg.in/gcfg.v1"
+)
+
+const (
+	EncryptionAESGCM    = "aes-gcm"     // nonce, ciphertext and tag
+	SignatureHMACSHA256 = "hmac-sha256" // 32 bytes after the signed payload
+	SignatureEd25519    = "ed25519"     // 64 bytes after the signed payload
+)
+
+// VerifyConf verifies and decrypts the payloads of devices, from a
+// [mqforward-verify "name"] section.
+type VerifyConf struct {
+	Topic      []string // topic patterns with the device capture, "devices/{device}/up"
+	Device     string   // name of the device capture, "device" by default
+	Keys       string   // key file of the devices
+	Encryption string   // "aes-gcm", or none if empty
+	Signature  string   // "hmac-sha256" or "ed25519", or none if empty
+}
+
+// DeviceKeysConf is a key file with hex encoded keys:
+//
+//	[device "sensor-1"]
+//	aesKey = 000102030405060708090a0b0c0d0e0f
+//	hmacKey = 6b6579
+type DeviceKeysConf struct {
+	Device map[string]*DeviceKeyConf
+}
+
+// DeviceKeyConf holds the keys of a device.
+type DeviceKeyConf struct {
+	AESKey     string // AES-128, AES-192 or AES-256 key
+	HMACKey    string // HMAC-SHA256 key
+	Ed25519Key string // public key
+}
+
+type deviceKey struct {
+	aead    cipher.AEAD
+	hmac    []byte
+	ed25519 ed25519.PublicKey
+}
+
+type verifyRule struct {
+	name       string
+	matchers   []*TopicMatcher
+	device     string
+	keys       map[string]*deviceKey
+	encryption string
+	signature  string
+}
+
+// Verifier checks the signature of payloads and decrypts them before they are
+// decoded. The keys are looked up by the device captured from the topic.
+type Verifier struct {
+	rules []verifyRule
+}
+
+func NewVerifier(conf *InfluxDBConf) (*Verifier, error) {
+	// sections are matched in name order
+	names := make([]string, 0, len(conf.Verify))
+	for name := range conf.Verify {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v := &Verifier{}
+	for _, name := range names {
+		rule, err := newVerifyRule(name, conf.Verify[name])
+		if err != nil {
+			return nil, fmt.Errorf("verify %s: %s", name, err)
+		}
+		v.rules = append(v.rules, *rule)
+	}
+	return v, nil
+}
+
+func newVerifyRule(name string, c *VerifyConf) (*verifyRule, error) {
+	rule := &verifyRule{
+		name:       name,
+		device:     c.Device,
+		encryption: c.Encryption,
+		signature:  c.Signature,
+	}
+	if rule.device == "" {
+		rule.device = "device"
+	}
+	if len(c.Topic) == 0 {
+		return nil, fmt.Errorf("topic is empty")
+	}
+	for _, t := range c.Topic {
+		if !hasCapture(t, rule.device) {
+			return nil, fmt.Errorf("topic %s has no {%s} capture", t, rule.device)
+		}
+		rule.matchers = append(rule.matchers, NewTopicMatcher(t))
+	}
+	switch rule.encryption {
+	case "", EncryptionAESGCM:
+	default:
+		return nil, fmt.Errorf("unknown encryption %q", rule.encryption)
+	}
+	switch rule.signature {
+	case "", SignatureHMACSHA256, SignatureEd25519:
+	default:
+		return nil, fmt.Errorf("unknown signature %q", rule.signature)
+	}
+	if rule.encryption == "" && rule.signature == "" {
+		return nil, fmt.Errorf("neither encryption nor signature is set")
+	}
+
+	if c.Keys == "" {
+		return nil, fmt.Errorf("keys is empty")
+	}
+	var keys DeviceKeysConf
+	if err := gcfg.ReadFileInto(&keys, ExpandPath(c.Keys)); err != nil {
+		return nil, err
+	}
+	rule.keys = map[string]*deviceKey{}
+	for device, k := range keys.Device {
+		key, err := newDeviceKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %s", device, err)
+		}
+		rule.keys[device] = key
+	}
+	return rule, nil
+}
+
+// hasCapture reports whether the topic pattern captures the name.
+func hasCapture(topic, name string) bool {
+	for _, part := range strings.Split(topic, MqttSeparator) {
+		if !IsSymbol(part) {
+			continue
+		}
+		if n, _ := SplitSymbol(part); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newDeviceKey(c *DeviceKeyConf) (*deviceKey, error) {
+	k := &deviceKey{}
+	if c.AESKey != "" {
+		b, err := hex.DecodeString(c.AESKey)
+		if err != nil {
+			return nil, fmt.Errorf("aesKey: %s", err)
+		}
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, fmt.Errorf("aesKey: %s", err)
+		}
+		if k.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("aesKey: %s", err)
+		}
+	}
+	if c.HMACKey != "" {
+		b, err := hex.DecodeString(c.HMACKey)
+		if err != nil {
+			return nil, fmt.Errorf("hmacKey: %s", err)
+		}
+		k.hmac = b
+	}
+	if c.Ed25519Key != "" {
+		b, err := hex.DecodeString(c.Ed25519Key)
+		if err != nil {
+			return nil, fmt.Errorf("ed25519Key: %s", err)
+		}
+		if len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519Key must be %d bytes", ed25519.PublicKeySize)
+		}
+		k.ed25519 = ed25519.PublicKey(b)
+	}
+	return k, nil
+}
+
+// Verify returns the message with the verified and decrypted payload. The
+// first section matching the topic as published is used, and messages of
+// other topics are returned as they are.
+func (v *Verifier) Verify(msg Message) (Message, error) {
+	topic := msg.PublishedTopic()
+	for _, rule := range v.rules {
+		for _, m := range rule.matchers {
+			ok, captures := m.Match(topic)
+			if !ok {
+				continue
+			}
+			payload, err := rule.verify(captures[rule.device], topic, msg.Payload)
+			if err != nil {
+				return msg, fmt.Errorf("verify %s: %s", rule.name, err)
+			}
+			msg.Payload = payload
+			return msg, nil
+		}
+	}
+	return msg, nil
+}
+
+// verify checks the signature after the payload, then decrypts the signed
+// payload. The topic is the additional data of AES-GCM, so that a payload
+// published on another topic is rejected.
+func (rule *verifyRule) verify(device, topic string, payload []byte) ([]byte, error) {
+	key, ok := rule.keys[device]
+	if !ok {
+		return nil, fmt.Errorf("no key of device %q", device)
+	}
+
+	switch rule.signature {
+	case SignatureHMACSHA256:
+		if key.hmac == nil {
+			return nil, fmt.Errorf("device %s has no hmac key", device)
+		}
+		if len(payload) < sha256.Size {
+			return nil, fmt.Errorf("payload is too short for a signature")
+		}
+		n := len(payload) - sha256.Size
+		mac := hmac.New(sha256.New, key.hmac)
+		mac.Write(payload[:n])
+		if !hmac.Equal(mac.Sum(nil), payload[n:]) {
+			return nil, fmt.Errorf("invalid signature of device %s", device)
+		}
+		payload = payload[:n]
+	case SignatureEd25519:
+		if key.ed25519 == nil {
+			return nil, fmt.Errorf("device %s has no ed25519 key", device)
+		}
+		if len(payload) < ed25519.SignatureSize {
+			return nil, fmt.Errorf("payload is too short for a signature")
+		}
+		n := len(payload) - ed25519.SignatureSize
+		if !ed25519.Verify(key.ed25519, payload[:n], payload[n:]) {
+			return nil, fmt.Errorf("invalid signature of device %s", device)
+		}
+		payload = payload[:n]
+	}
+
+	if rule.encryption == EncryptionAESGCM {
+		if key.aead == nil {
+			return nil, fmt.Errorf("device %s has no aes key", device)
+		}
+		size := key.aead.NonceSize()
+		if len(payload) < size+key.aead.Overhead() {
+			return nil, fmt.Errorf("payload is too short for aes-gcm")
+		}
+		b, err := key.aead.Open(nil, payload[:size], payload[size:], []byte(topic))
+		if err != nil {
+			return nil, fmt.Errorf("decrypting payload of device %s: %s", device, err)
+		}
+		payload = b
+	}
+	return payload, nil
+}
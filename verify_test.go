@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testAESKey  = []byte("0123456789abcdef")
+	testHMACKey = []byte("secret")
+	testEd25519 = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+)
+
+func testKeys(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "keys.ini")
+	keys := fmt.Sprintf(`
+[device "sensor-1"]
+aesKey = %s
+hmacKey = %s
+
+[device "sensor-2"]
+ed25519Key = %s
+`, hex.EncodeToString(testAESKey), hex.EncodeToString(testHMACKey),
+		hex.EncodeToString(testEd25519.Public().(ed25519.PublicKey)))
+	if err := ioutil.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sealed encrypts the payload for the topic with AES-GCM and appends an
+// HMAC-SHA256.
+func sealed(topic string, payload []byte) []byte {
+	block, _ := aes.NewCipher(testAESKey)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	b := aead.Seal(nonce, nonce, payload, []byte(topic))
+	mac := hmac.New(sha256.New, testHMACKey)
+	mac.Write(b)
+	return mac.Sum(b)
+}
+
+func Test_Verifier(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := testKeys(t)
+	v, err := NewVerifier(&InfluxDBConf{
+		Verify: map[string]*VerifyConf{
+			"a-sealed": {
+				Topic:      []string{"sealed/{device}/up"},
+				Keys:       keys,
+				Encryption: EncryptionAESGCM,
+				Signature:  SignatureHMACSHA256,
+			},
+			"b-signed": {
+				Topic:     []string{"signed/{id}"},
+				Device:    "id",
+				Keys:      keys,
+				Signature: SignatureEd25519,
+			},
+		},
+	})
+	assert.Nil(err)
+
+	payload := []byte(`{"temp": 21.5}`)
+	msg, err := v.Verify(Message{Topic: "sealed/sensor-1/up", Payload: sealed("sealed/sensor-1/up", payload)})
+	assert.Nil(err)
+	assert.Equal(payload, msg.Payload)
+
+	signed := append(payload[:len(payload):len(payload)], ed25519.Sign(testEd25519, payload)...)
+	msg, err = v.Verify(Message{Topic: "signed/sensor-2", Payload: signed})
+	assert.Nil(err)
+	assert.Equal(payload, msg.Payload)
+
+	// topics without verification are passed
+	msg, err = v.Verify(Message{Topic: "plain/sensor-1", Payload: payload})
+	assert.Nil(err)
+	assert.Equal(payload, msg.Payload)
+
+	tampered := sealed("sealed/sensor-1/up", payload)
+	tampered[20] ^= 1
+	invalid := []Message{
+		{Topic: "sealed/sensor-1/up", Payload: tampered},
+		{Topic: "sealed/sensor-1/up", Payload: payload},
+		{Topic: "sealed/sensor-3/up", Payload: sealed("sealed/sensor-3/up", payload)},
+		{Topic: "sealed/sensor-2/up", Payload: sealed("sealed/sensor-2/up", payload)},
+		// sealed for another topic of the device
+		{Topic: "sealed/sensor-1/up", Payload: sealed("sealed/sensor-1/down", payload)},
+		{Topic: "signed/sensor-2", Payload: append([]byte("x"), signed...)},
+		{Topic: "signed/sensor-1", Payload: signed},
+	}
+	for i, m := range invalid {
+		_, err := v.Verify(m)
+		assert.NotNil(err, "case %d", i)
+	}
+}
+
+func Test_VerifierConf(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := testKeys(t)
+	invalid := []*VerifyConf{
+		{Keys: keys, Signature: SignatureEd25519},
+		{Topic: []string{"a/{device}"}, Keys: keys},
+		{Topic: []string{"a/{device}"}, Keys: keys, Encryption: "aes-cbc"},
+		{Topic: []string{"a/{device}"}, Keys: keys, Signature: "rsa"},
+		{Topic: []string{"a/{device}"}, Signature: SignatureEd25519},
+		{Topic: []string{"a/{device}"}, Keys: keys + ".missing", Signature: SignatureEd25519},
+		{Topic: []string{"a/{id}"}, Keys: keys, Signature: SignatureEd25519},
+		{Topic: []string{"a/{device}", "b/+"}, Keys: keys, Signature: SignatureEd25519},
+		{Topic: []string{"a/{device}"}, Device: "id", Keys: keys, Signature: SignatureEd25519},
+	}
+	for i, c := range invalid {
+		_, err := NewVerifier(&InfluxDBConf{Verify: map[string]*VerifyConf{"a": c}})
+		assert.NotNil(err, "case %d", i)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.ini")
+	assert.Nil(ioutil.WriteFile(path, []byte("[device \"a\"]\naesKey = 0102\n"), 0600))
+	_, err := NewVerifier(&InfluxDBConf{Verify: map[string]*VerifyConf{
+		"a": {Topic: []string{"a/{device}"}, Keys: path, Encryption: EncryptionAESGCM},
+	}})
+	assert.NotNil(err)
+}
+
+func Test_EncodeUnverified(t *testing.T) {
+	assert := assert.New(t)
+
+	coder, err := NewMqttSeriesEncoder(&InfluxDBConf{
+		Verify: map[string]*VerifyConf{
+			"sealed": {
+				Topic:      []string{"sealed/{device}"},
+				Keys:       testKeys(t),
+				Encryption: EncryptionAESGCM,
+				Signature:  SignatureHMACSHA256,
+			},
+		},
+	})
+	assert.Nil(err)
+
+	unverified := stats.unverified.Load()
+	points := coder.Encode(Message{Topic: "sealed/sensor-1", Payload: sealed("sealed/sensor-1", []byte(`{"temp": 21.5}`))})
+	assert.Equal(1, len(points))
+	assert.Equal(0, len(coder.Encode(Message{Topic: "sealed/sensor-1", Payload: []byte(`{"temp": 99}`)})))
+	assert.Equal(unverified+1, stats.unverified.Load())
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XMLPathSeparator separates the elements of XML paths. The last element of a
+// path may be an attribute, "@name".
+const XMLPathSeparator = "/"
+
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*xmlNode
+}
+
+type xmlSelect struct {
+	field string
+	path  []string
+	typ   string // empty if the value type is guessed
+}
+
+// XMLDecoder decodes an XML document. Without selections, the text of every
+// leaf element and every attribute is a field named by its path below the
+// document element, joined with "_". Attributes are named "@name" so that
+// they do not collide with child elements.
+type XMLDecoder struct {
+	selects []xmlSelect
+}
+
+func NewXMLDecoder(f *FormatConf) (*XMLDecoder, error) {
+	d := &XMLDecoder{}
+	for _, s := range f.Select {
+		v := strings.Fields(s)
+		if len(v) < 2 || len(v) > 3 {
+			return nil, fmt.Errorf("xml: select must be 'name path [type]': %s", s)
+		}
+		sel := xmlSelect{
+			field: v[0],
+			path:  strings.Split(strings.TrimPrefix(v[1], XMLPathSeparator), XMLPathSeparator),
+		}
+		if len(v) == 3 {
+			if !validFieldType(v[2]) {
+				return nil, fmt.Errorf("xml: select %s: unknown type %q", v[0], v[2])
+			}
+			sel.typ = v[2]
+		}
+		d.selects = append(d.selects, sel)
+	}
+	return d, nil
+}
+
+func (d *XMLDecoder) ContentType() string {
+	return "application/xml"
+}
+
+func (d *XMLDecoder) Detect(msg Message) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(msg.Payload), []byte("<"))
+}
+
+func (d *XMLDecoder) Decode(msg Message) ([]Record, error) {
+	root, err := parseXML(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if len(d.selects) == 0 {
+		root.leaves("", fields)
+		return fieldRecords(fields), nil
+	}
+	for _, s := range d.selects {
+		text, ok := root.find(s.path)
+		if !ok {
+			continue
+		}
+		if s.typ == "" {
+			fields[s.field] = textValue(text)
+			continue
+		}
+		v, err := convertField(text, s.typ)
+		if err != nil {
+			return nil, fmt.Errorf("xml: %s: %s", s.field, err)
+		}
+		fields[s.field] = v
+	}
+	return fieldRecords(fields), nil
+}
+
+// parseXML returns the document element.
+func parseXML(b []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	var root *xmlNode
+	stack := []*xmlNode{}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, attrs: map[string]string{}}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("xml: no element")
+	}
+	return root, nil
+}
+
+// find returns the text or the attribute at the path, which starts with the
+// document element. The first of repeated elements is used.
+func (n *xmlNode) find(path []string) (string, bool) {
+	if len(path) == 0 || path[0] != n.name {
+		return "", false
+	}
+	node := n
+	for _, name := range path[1:] {
+		if strings.HasPrefix(name, "@") {
+			v, ok := node.attrs[name[1:]]
+			return v, ok
+		}
+		var next *xmlNode
+		for _, c := range node.children {
+			if c.name == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return "", false
+		}
+		node = next
+	}
+	return strings.TrimSpace(node.text), true
+}
+
+// leaves adds the attributes and the text of leaf elements to the fields.
+func (n *xmlNode) leaves(prefix string, fields map[string]interface{}) {
+	for k, v := range n.attrs {
+		fields[joinKey(prefix, "@"+k)] = textValue(v)
+	}
+	if len(n.children) == 0 && prefix != "" {
+		if text := strings.TrimSpace(n.text); text != "" {
+			fields[prefix] = textValue(text)
+		}
+	}
+	for _, c := range n.children {
+		c.leaves(joinKey(prefix, c.name), fields)
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+// textValue returns a number or the text.
+func textValue(s string) interface{} {
+	if v, err := parseNumber(strings.TrimSpace(s)); err == nil {
+		return v
+	}
+	return s
+}
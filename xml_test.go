@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testXML = `<?xml version="1.0"?>
+<data device="gw1">
+  <temp>21.5</temp>
+  <hum unit="%">40</hum>
+  <sensor id="a"><state>ok</state></sensor>
+  <sensor id="b"><state>fail</state></sensor>
+</data>`
+
+func Test_XMLDecoder(t *testing.T) {
+	assert := assert.New(t)
+
+	d, err := NewXMLDecoder(&FormatConf{})
+	assert.Nil(err)
+	assert.True(d.Detect(Message{Payload: []byte(testXML)}))
+
+	records, err := d.Decode(Message{Payload: []byte(testXML)})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"@device":      "gw1",
+		"temp":         21.5,
+		"hum":          int64(40),
+		"hum_@unit":    "%",
+		"sensor_@id":   "b",
+		"sensor_state": "fail",
+	}, records[0].Fields)
+
+	// attributes do not collide with child elements of the same name
+	records, err = d.Decode(Message{Payload: []byte(`<data id="gw1"><id>7</id></data>`)})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"@id": "gw1", "id": int64(7)}, records[0].Fields)
+
+	d, err = NewXMLDecoder(&FormatConf{Select: []string{
+		"temperature /data/temp",
+		"humidity data/hum float",
+		"gateway data/@device",
+		"state data/sensor/state",
+		"missing data/pressure",
+	}})
+	assert.Nil(err)
+	records, err = d.Decode(Message{Payload: []byte(testXML)})
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"temperature": 21.5,
+		"humidity":    float64(40),
+		"gateway":     "gw1",
+		"state":       "ok",
+	}, records[0].Fields)
+
+	_, err = d.Decode(Message{Payload: []byte(`<data><temp>1</data>`)})
+	assert.NotNil(err)
+
+	_, err = NewXMLDecoder(&FormatConf{Select: []string{"temp"}})
+	assert.NotNil(err)
+	_, err = NewXMLDecoder(&FormatConf{Select: []string{"temp data/temp decimal"}})
+	assert.NotNil(err)
+}
+
+func Test_DecoderRegistryXML(t *testing.T) {
+	assert := assert.New(t)
+
+	r, err := NewDecoderRegistry(&InfluxDBConf{})
+	assert.Nil(err)
+	_, d, err := r.Decode(Message{Payload: []byte(testXML)})
+	assert.Nil(err)
+	assert.Equal("application/xml", d.ContentType())
+}